@@ -0,0 +1,255 @@
+// Package broadcast implements a cache.Cache that broadcasts deleted keys to the local caches of other instances.
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Bus broadcasts invalidated keys to all instances that subscribed to it.
+type Bus interface {
+	// Publish broadcasts the keys to all subscribers.
+	Publish(ctx context.Context, keys ...string) error
+	// Subscribe calls f with the keys of every message that is published on the Bus.
+	// It calls ready once the subscription is established and blocks until the context is done or the subscription fails.
+	Subscribe(ctx context.Context, ready func(), f func(keys ...string)) error
+}
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// WithLogger can overwrite the default Noop logger of the Cache.
+func WithLogger(l log.Logger) Option {
+	return func(c *Cache) {
+		c.l = l
+	}
+}
+
+// WithBackoff configures the minimum and maximum time the Cache waits before subscribing again.
+// The backoff is doubled for every consecutive failure and reset once a subscription was established.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Cache) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// Cache wraps a local cache, e.g. go-cache, and keeps it consistent with the local caches of other instances.
+// Keys that are deleted from the Cache are published on the Bus and deleted from the local cache of every instance that runs Cache.Run.
+// Written values are not published, so an entry that must be invalidated on all instances has to be deleted instead of overwritten.
+// zedcache deletes the keys of writes before the write and the dependency keys of object types after it, see zedcache.WithSchema.
+//
+// Messages that are published while an instance is not subscribed to the Bus are lost.
+// Lookups therefore miss until Run subscribed to the Bus, and all cached values are
+// discarded whenever the subscription is established again.
+// Other instances can still return a deleted value until they received the message,
+// which is usually a matter of milliseconds.
+type Cache struct {
+	c          cache.ContextCache
+	b          Bus
+	l          log.Logger
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// mu guards the generation and whether the Cache is subscribed.
+	// Values are stored with the generation in which they were set.
+	// Values of previous generations are treated as missing.
+	mu         sync.RWMutex
+	generation uint64
+	subscribed bool
+}
+
+var (
+	_ cache.Cache          = &Cache{}
+	_ cache.ContextCache   = &Cache{}
+	_ cache.MonotonicCache = &Cache{}
+	_ cache.TTLCache       = &Cache{}
+)
+
+// New creates a Cache that stores values in the local cache c and broadcasts deleted keys on the Bus b.
+// Run must be called to receive the keys that other instances deleted.
+func New(c cache.Cache, b Bus, opts ...Option) *Cache {
+	bc := &Cache{
+		c:          cache.WithContext(c),
+		b:          b,
+		l:          log.NewNopLogger(),
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, o := range opts {
+		o(bc)
+	}
+
+	return bc
+}
+
+// Run subscribes to the Bus and deletes the received keys from the local cache until the given context is canceled.
+// If the subscription fails, Run subscribes again with an exponential backoff.
+func (c *Cache) Run(ctx context.Context) error {
+	backoff := c.minBackoff
+	for {
+		subscribed := false
+		err := c.b.Subscribe(ctx, func() {
+			subscribed = true
+			c.setSubscribed(true)
+		}, c.invalidate)
+		c.setSubscribed(false)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if subscribed {
+			backoff = c.minBackoff
+		}
+		level.Warn(c.l).Log("msg", "invalidation subscription failed", "err", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// setSubscribed records whether the Cache is subscribed to the Bus.
+// Establishing a subscription starts a new generation,
+// because keys could have been deleted by other instances while the Cache was not subscribed.
+func (c *Cache) setSubscribed(subscribed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if subscribed {
+		c.generation++
+	}
+	c.subscribed = subscribed
+}
+
+// invalidate deletes keys that were published on the Bus from the local cache.
+func (c *Cache) invalidate(keys ...string) {
+	if err := c.c.DelContext(context.Background(), keys...); err != nil {
+		level.Error(c.l).Log("msg", "failed to delete invalidated keys", "err", err.Error())
+	}
+}
+
+func (c *Cache) Get(key string) (string, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *Cache) Set(key, value string) error {
+	return c.SetContext(context.Background(), key, value)
+}
+
+func (c *Cache) Del(keys ...string) error {
+	return c.DelContext(context.Background(), keys...)
+}
+
+func (c *Cache) GetContext(ctx context.Context, key string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.subscribed {
+		return "", cache.ErrCacheMiss
+	}
+	v, err := c.c.GetContext(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	g, v, err := decode(v)
+	if err != nil || g != c.generation {
+		return "", cache.ErrCacheMiss
+	}
+
+	return v, nil
+}
+
+func (c *Cache) SetContext(ctx context.Context, key, value string) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+func (c *Cache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return cache.SetWithTTL(ctx, c.c, key, encode(c.generation, value), ttl)
+}
+
+// SetIfNewer sets the key to value, unless the cached value of the current generation is at least as new as value.
+func (c *Cache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	g := c.generation
+	return cache.SetIfNewer(ctx, c.c, key, encode(g, value), ttl, func(a, b string) (int, error) {
+		ga, va, err := decode(a)
+		if err != nil {
+			return 0, err
+		}
+		gb, vb, err := decode(b)
+		if err != nil {
+			return 0, err
+		}
+		// Values of previous generations are older than any value of the current one.
+		switch {
+		case ga != g && gb == g:
+			return -1, nil
+		case ga == g && gb != g:
+			return 1, nil
+		}
+
+		return cmp(va, vb)
+	})
+}
+
+// DelContext deletes the keys from the local cache and publishes them on the Bus.
+func (c *Cache) DelContext(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := c.c.DelContext(ctx, keys...); err != nil {
+		return err
+	}
+	if err := c.b.Publish(ctx, keys...); err != nil {
+		return fmt.Errorf("failed to publish invalidated keys: %w", err)
+	}
+
+	return nil
+}
+
+var errInvalidValue = errors.New("invalid value")
+
+// encode prefixes the value with the generation.
+func encode(generation uint64, value string) string {
+	return strconv.FormatUint(generation, 10) + ":" + value
+}
+
+// decode splits a value that was encoded with encode into the generation and the value.
+func decode(s string) (uint64, string, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return 0, "", errInvalidValue
+	}
+	g, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return 0, "", errInvalidValue
+	}
+
+	return g, s[i+1:], nil
+}
@@ -0,0 +1,170 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/cachetest"
+	"github.com/connylabs/zedcache/cache/go-cache"
+)
+
+// memBus is an in-memory Bus.
+type memBus struct {
+	mu   sync.Mutex
+	subs map[int]chan []string
+	next int
+	// fail ends all subscriptions.
+	fail chan struct{}
+}
+
+func newMemBus() *memBus {
+	return &memBus{subs: make(map[int]chan []string), fail: make(chan struct{})}
+}
+
+func (b *memBus) Publish(_ context.Context, keys ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.subs {
+		ch <- keys
+	}
+
+	return nil
+}
+
+func (b *memBus) Subscribe(ctx context.Context, ready func(), f func(keys ...string)) error {
+	ch := make(chan []string, 100)
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.subs[id] = ch
+	fail := b.fail
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}()
+
+	ready()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-fail:
+			return errors.New("subscription failed")
+		case keys := <-ch:
+			f(keys...)
+		}
+	}
+}
+
+// disconnect ends all subscriptions.
+func (b *memBus) disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	close(b.fail)
+	b.fail = make(chan struct{})
+}
+
+func newGocache() *gocache.Cache {
+	return gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))
+}
+
+// run runs the Cache until the test ends and waits until it subscribed.
+func run(t *testing.T, c *Cache) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, c.Run(ctx))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitSubscribed(t, c)
+}
+
+func waitSubscribed(t *testing.T, c *Cache) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+
+		return c.subscribed
+	}, time.Second, time.Millisecond)
+}
+
+func TestCache(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		c := New(newGocache(), newMemBus())
+		run(t, c)
+
+		return c
+	})
+}
+
+func TestBroadcast(t *testing.T) {
+	t.Run("not subscribed", func(t *testing.T) {
+		c := New(newGocache(), newMemBus())
+		require.NoError(t, c.Set("a", "1"))
+
+		_, err := c.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+	})
+
+	t.Run("invalidate other instances", func(t *testing.T) {
+		b := newMemBus()
+		c1, c2 := New(newGocache(), b), New(newGocache(), b)
+		run(t, c1)
+		run(t, c2)
+		require.NoError(t, c1.Set("a", "1"))
+		require.NoError(t, c2.Set("a", "1"))
+
+		require.NoError(t, c1.Del("a"))
+		require.Eventually(t, func() bool {
+			_, err := c2.Get("a")
+			return errors.Is(err, cache.ErrCacheMiss)
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("resubscribe", func(t *testing.T) {
+		b := newMemBus()
+		c := New(newGocache(), b, WithBackoff(time.Millisecond, time.Millisecond))
+		run(t, c)
+		require.NoError(t, c.Set("a", "1"))
+
+		b.disconnect()
+		// Keys that are deleted while the Cache is not subscribed are not received.
+		require.Eventually(t, func() bool {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+
+			return c.generation == 2
+		}, time.Second, time.Millisecond)
+		waitSubscribed(t, c)
+
+		_, err := c.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+		// Values of previous generations are replaced, even if cmp considers them newer.
+		require.NoError(t, c.SetIfNewer(context.Background(), "a", "0", 0, func(a, b string) (int, error) {
+			return 1, nil
+		}))
+		v, err := c.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, "0", v)
+	})
+}
@@ -1,7 +1,11 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
 )
 
 var ErrCacheMiss = errors.New("cache miss")
@@ -16,3 +20,183 @@ type Cache interface {
 	// to still gain access with on old cached token.
 	Del(...string) error
 }
+
+// ContextCache is a Cache whose operations honor the deadline and cancellation of a context.Context.
+// The same consistency requirements as for Cache.Del apply to DelContext.
+type ContextCache interface {
+	GetContext(context.Context, string) (string, error)
+	SetContext(context.Context, string, string) error
+	DelContext(context.Context, ...string) error
+}
+
+// WithContext returns a ContextCache for the given Cache.
+// If c already implements ContextCache, it is returned as is.
+// Otherwise every operation is run in its own goroutine and
+// returns the context's error as soon as the context is done.
+func WithContext(c Cache) ContextCache {
+	if cc, ok := c.(ContextCache); ok {
+		return cc
+	}
+
+	return &contextCache{c}
+}
+
+type contextCache struct {
+	c Cache
+}
+
+func (cc *contextCache) GetContext(ctx context.Context, key string) (string, error) {
+	var v string
+	err := do(ctx, func() (err error) {
+		v, err = cc.c.Get(key)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return v, nil
+}
+
+func (cc *contextCache) SetContext(ctx context.Context, key, value string) error {
+	return do(ctx, func() error {
+		return cc.c.Set(key, value)
+	})
+}
+
+func (cc *contextCache) DelContext(ctx context.Context, keys ...string) error {
+	return do(ctx, func() error {
+		return cc.c.Del(keys...)
+	})
+}
+
+// do runs f and waits until it returns or the context is done.
+func do(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// The channel is buffered so that the goroutine does not leak if the context is done first.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// Compare compares two cached values.
+// The result must be negative if a is older than b, 0 if both are equally new, and positive if a is newer than b.
+type Compare func(a, b string) (int, error)
+
+// TTLCache is implemented by caches whose entries can expire.
+type TTLCache interface {
+	// SetWithTTL sets the key to value. The entry expires after ttl.
+	// A ttl of 0 means that the entry does not expire.
+	SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error
+}
+
+// SetWithTTL sets the key to value. The entry expires after ttl.
+// A ttl of 0 means that the entry does not expire.
+// If c does not implement TTLCache, the ttl is ignored.
+func SetWithTTL(ctx context.Context, c ContextCache, key, value string, ttl time.Duration) error {
+	if tc, ok := c.(TTLCache); ok {
+		return tc.SetWithTTL(ctx, key, value, ttl)
+	}
+
+	return c.SetContext(ctx, key, value)
+}
+
+// MonotonicCache is implemented by caches that can atomically replace a cached value only with a newer one.
+type MonotonicCache interface {
+	// SetIfNewer sets the key to value, unless the cached value is at least as new as value according to cmp.
+	// If cmp returns an error, value is set.
+	// The entry expires after ttl. A ttl of 0 means that the entry does not expire.
+	SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp Compare) error
+}
+
+// SetIfNewer sets the key to value, unless the cached value is at least as new as value according to cmp.
+// The entry expires after ttl. A ttl of 0 means that the entry does not expire.
+// If c does not implement MonotonicCache, the cached value is read and compared before it is overwritten.
+// This is not atomic, so concurrent writers can still move the cached value backwards.
+func SetIfNewer(ctx context.Context, c ContextCache, key, value string, ttl time.Duration, cmp Compare) error {
+	if mc, ok := c.(MonotonicCache); ok {
+		return mc.SetIfNewer(ctx, key, value, ttl, cmp)
+	}
+
+	old, err := c.GetContext(ctx, key)
+	if err != nil && !errors.Is(err, ErrCacheMiss) {
+		return err
+	}
+	if err == nil && !IsNewer(value, old, cmp) {
+		return nil
+	}
+
+	return SetWithTTL(ctx, c, key, value, ttl)
+}
+
+// IsNewer reports whether value should replace the cached value old according to cmp.
+// Values that can not be compared are considered newer.
+func IsNewer(value, old string, cmp Compare) bool {
+	c, err := cmp(old, value)
+
+	return err != nil || c < 0
+}
+
+// BatchCache is implemented by caches that can look up and write many keys in a single round trip.
+type BatchCache interface {
+	// GetMulti looks up the given keys. The returned map only contains the keys that are cached.
+	GetMulti(ctx context.Context, keys ...string) (map[string]string, error)
+	// SetMulti sets every key of values to its value.
+	// If cmp is not nil, a key is only set if its cached value is not at least as new as the value, like with SetIfNewer.
+	// The entries expire after ttl. A ttl of 0 means that the entries do not expire.
+	SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp Compare) error
+}
+
+// GetMulti looks up the given keys. The returned map only contains the keys that are cached.
+// If c does not implement BatchCache, the keys are looked up one by one.
+func GetMulti(ctx context.Context, c ContextCache, keys ...string) (map[string]string, error) {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.GetMulti(ctx, keys...)
+	}
+
+	values := make(map[string]string, len(keys))
+	for _, k := range keys {
+		v, err := c.GetContext(ctx, k)
+		if errors.Is(err, ErrCacheMiss) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[k] = v
+	}
+
+	return values, nil
+}
+
+// SetMulti sets every key of values to its value.
+// If cmp is not nil, a key is only set if its cached value is not at least as new as the value, like with SetIfNewer.
+// The entries expire after ttl. A ttl of 0 means that the entries do not expire.
+// If c does not implement BatchCache, the keys are set concurrently with SetIfNewer or SetWithTTL.
+func SetMulti(ctx context.Context, c ContextCache, values map[string]string, ttl time.Duration, cmp Compare) error {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.SetMulti(ctx, values, ttl, cmp)
+	}
+
+	g := multierror.Group{}
+	for k, v := range values {
+		key, value := k, v
+		g.Go(func() error {
+			if cmp == nil {
+				return SetWithTTL(ctx, c, key, value, ttl)
+			}
+			return SetIfNewer(ctx, c, key, value, ttl, cmp)
+		})
+	}
+
+	return g.Wait().ErrorOrNil()
+}
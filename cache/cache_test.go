@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type blockingCache struct {
+	unblock chan struct{}
+}
+
+func (c *blockingCache) Get(string) (string, error) {
+	<-c.unblock
+	return "value", nil
+}
+
+func (c *blockingCache) Set(string, string) error {
+	<-c.unblock
+	return nil
+}
+
+func (c *blockingCache) Del(...string) error {
+	<-c.unblock
+	return nil
+}
+
+func TestWithContext(t *testing.T) {
+	t.Run("deadline", func(t *testing.T) {
+		c := &blockingCache{unblock: make(chan struct{})}
+		t.Cleanup(func() { close(c.unblock) })
+		cc := WithContext(c)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := cc.GetContext(ctx, "key")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, cc.SetContext(ctx, "key", "value"), context.DeadlineExceeded)
+		assert.ErrorIs(t, cc.DelContext(ctx, "key"), context.DeadlineExceeded)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		c := &blockingCache{unblock: make(chan struct{})}
+		close(c.unblock)
+		cc := WithContext(c)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := cc.GetContext(ctx, "key")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("passthrough", func(t *testing.T) {
+		c := &blockingCache{unblock: make(chan struct{})}
+		close(c.unblock)
+		cc := WithContext(c)
+
+		v, err := cc.GetContext(context.Background(), "key")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", v)
+		assert.NoError(t, cc.SetContext(context.Background(), "key", "value"))
+		assert.NoError(t, cc.DelContext(context.Background(), "key"))
+	})
+}
+
+// mapCache is a Cache that implements none of the optional interfaces.
+type mapCache struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (c *mapCache) Get(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.values[key]
+	if !ok {
+		return "", ErrCacheMiss
+	}
+
+	return v, nil
+}
+
+func (c *mapCache) Set(key, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key] = value
+
+	return nil
+}
+
+func (c *mapCache) Del(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range keys {
+		delete(c.values, k)
+	}
+
+	return nil
+}
+
+func TestBatchFallback(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("get multi", func(t *testing.T) {
+		cc := WithContext(&mapCache{values: map[string]string{"a": "1", "b": "2"}})
+
+		v, err := GetMulti(ctx, cc, "a", "b", "missing")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, v)
+	})
+
+	t.Run("set multi", func(t *testing.T) {
+		c := &mapCache{values: map[string]string{"a": "old"}}
+
+		require.NoError(t, SetMulti(ctx, WithContext(c), map[string]string{"a": "1", "b": "2"}, 0, nil))
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, c.values)
+	})
+
+	t.Run("set multi if newer", func(t *testing.T) {
+		c := &mapCache{values: map[string]string{"a": "a", "b": "c"}}
+		cmp := func(a, b string) (int, error) {
+			return strings.Compare(a, b), nil
+		}
+
+		require.NoError(t, SetMulti(ctx, WithContext(c), map[string]string{"a": "b", "b": "b", "c": "b"}, 0, cmp))
+		assert.Equal(t, map[string]string{"a": "b", "b": "c", "c": "b"}, c.values)
+	})
+}
@@ -0,0 +1,98 @@
+package cachetest
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// BenchmarkFactory creates a new and empty cache for a single benchmark.
+type BenchmarkFactory func(b *testing.B) cache.Cache
+
+const (
+	benchmarkKeys  = 10000
+	benchmarkBatch = 16
+)
+
+// benchmarkValue has the size of a typical zedtoken.
+var benchmarkValue = strings.Repeat("x", 48)
+
+func benchmarkKey(i int) string {
+	return "document#" + strconv.Itoa(i%benchmarkKeys)
+}
+
+// Benchmark runs parallel benchmarks of the access patterns of zedcache against the caches created by f:
+// lookups of cached keys, writes, a mix of 90% lookups and 10% writes, and deletions of batches of keys.
+func Benchmark(b *testing.B, f BenchmarkFactory) {
+	b.Run("get", func(b *testing.B) {
+		c := fill(b, f)
+		b.ResetTimer()
+		parallel(b, func(i int) error {
+			_, err := c.Get(benchmarkKey(i))
+			return err
+		})
+	})
+
+	b.Run("set", func(b *testing.B) {
+		c := f(b)
+		b.ResetTimer()
+		parallel(b, func(i int) error {
+			return c.Set(benchmarkKey(i), benchmarkValue)
+		})
+	})
+
+	b.Run("mixed", func(b *testing.B) {
+		c := fill(b, f)
+		b.ResetTimer()
+		parallel(b, func(i int) error {
+			if i%10 == 0 {
+				return c.Set(benchmarkKey(i), benchmarkValue)
+			}
+			_, err := c.Get(benchmarkKey(i))
+			return err
+		})
+	})
+
+	b.Run("del batch", func(b *testing.B) {
+		c := fill(b, f)
+		b.ResetTimer()
+		parallel(b, func(i int) error {
+			keys := make([]string, benchmarkBatch)
+			for j := range keys {
+				keys[j] = benchmarkKey(i*benchmarkBatch + j)
+			}
+			return c.Del(keys...)
+		})
+	})
+}
+
+// fill creates a cache and sets all keys that are used by the benchmarks.
+func fill(b *testing.B, f BenchmarkFactory) cache.Cache {
+	b.Helper()
+
+	c := f(b)
+	for i := 0; i < benchmarkKeys; i++ {
+		if err := c.Set(benchmarkKey(i), benchmarkValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return c
+}
+
+// parallel runs op with increasing indexes in parallel goroutines. Cache misses are not treated as errors.
+func parallel(b *testing.B, op func(i int) error) {
+	var n int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := op(int(atomic.AddInt64(&n, 1))); err != nil && !errors.Is(err, cache.ErrCacheMiss) {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,399 @@
+// Package cachetest provides a conformance test suite for implementations of cache.Cache.
+//
+// Backends call Run from their own tests:
+//
+//	func TestCache(t *testing.T) {
+//		cachetest.Run(t, func(t *testing.T) cache.Cache {
+//			return mycache.New()
+//		})
+//	}
+//
+// Optional interfaces like cache.ContextCache, cache.TTLCache and cache.MonotonicCache
+// are tested if the cache implements them.
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// Factory creates a new and empty cache for a single test.
+type Factory func(t *testing.T) cache.Cache
+
+// Option configures the test suite.
+type Option func(*suite)
+
+// Lossy declares that the cache may drop entries at any time, e.g. a cache that never stores anything.
+// Tests that expect a hit also accept a miss, but a deleted entry must still never be returned.
+func Lossy() Option {
+	return func(s *suite) {
+		s.lossy = true
+	}
+}
+
+// TTLResolution sets the resolution of the cache's expirations.
+// Entries are expected to expire within two times the resolution after their ttl.
+// The default is 50ms.
+func TTLResolution(d time.Duration) Option {
+	return func(s *suite) {
+		s.ttlResolution = d
+	}
+}
+
+// LargeValueSize sets the size in bytes of the value used to test large values.
+// The default is 256KiB.
+func LargeValueSize(n int) Option {
+	return func(s *suite) {
+		s.largeValueSize = n
+	}
+}
+
+type suite struct {
+	f              Factory
+	lossy          bool
+	ttlResolution  time.Duration
+	largeValueSize int
+}
+
+// Run runs the conformance test suite against the caches created by f.
+func Run(t *testing.T, f Factory, opts ...Option) {
+	s := &suite{
+		f:              f,
+		ttlResolution:  50 * time.Millisecond,
+		largeValueSize: 256 << 10,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+
+	t.Run("miss", s.testMiss)
+	t.Run("hit", s.testHit)
+	t.Run("hit after overwrite", s.testOverwrite)
+	t.Run("empty value", s.testEmptyValue)
+	t.Run("large value", s.testLargeValue)
+	t.Run("del", s.testDel)
+	t.Run("del multiple keys", s.testDelMultiple)
+	t.Run("del missing key", s.testDelMissing)
+	t.Run("concurrent access", s.testConcurrent)
+	t.Run("context", s.testContext)
+	t.Run("ttl", s.testTTL)
+	t.Run("set if newer", s.testSetIfNewer)
+	t.Run("concurrent set if newer", s.testConcurrentSetIfNewer)
+	t.Run("get multi", s.testGetMulti)
+	t.Run("set multi", s.testSetMulti)
+	t.Run("set multi if newer", s.testSetMultiIfNewer)
+}
+
+// requireValue asserts that key is cached with value.
+// Lossy caches may also miss.
+func (s *suite) requireValue(t *testing.T, c cache.Cache, key, value string) {
+	t.Helper()
+
+	v, err := c.Get(key)
+	if s.lossy && errors.Is(err, cache.ErrCacheMiss) {
+		return
+	}
+	require.NoError(t, err)
+	require.Equal(t, value, v)
+}
+
+// requireMiss asserts that key is not cached.
+func requireMiss(t *testing.T, c cache.Cache, key string) {
+	t.Helper()
+
+	_, err := c.Get(key)
+	require.ErrorIs(t, err, cache.ErrCacheMiss)
+}
+
+func (s *suite) testMiss(t *testing.T) {
+	requireMiss(t, s.f(t), "key")
+}
+
+func (s *suite) testHit(t *testing.T) {
+	c := s.f(t)
+
+	require.NoError(t, c.Set("key", "value"))
+	s.requireValue(t, c, "key", "value")
+}
+
+func (s *suite) testOverwrite(t *testing.T) {
+	c := s.f(t)
+
+	require.NoError(t, c.Set("key", "value"))
+	require.NoError(t, c.Set("key", "value!"))
+	s.requireValue(t, c, "key", "value!")
+}
+
+func (s *suite) testEmptyValue(t *testing.T) {
+	c := s.f(t)
+
+	require.NoError(t, c.Set("key", ""))
+	s.requireValue(t, c, "key", "")
+}
+
+func (s *suite) testLargeValue(t *testing.T) {
+	c := s.f(t)
+	value := strings.Repeat("z", s.largeValueSize)
+
+	require.NoError(t, c.Set("key", value))
+	s.requireValue(t, c, "key", value)
+}
+
+func (s *suite) testDel(t *testing.T) {
+	c := s.f(t)
+
+	require.NoError(t, c.Set("key", "value"))
+	s.requireValue(t, c, "key", "value")
+	require.NoError(t, c.Del("key"))
+	requireMiss(t, c, "key")
+}
+
+func (s *suite) testDelMultiple(t *testing.T) {
+	c := s.f(t)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.Set(fmt.Sprintf("key%d", i), "value"))
+	}
+	require.NoError(t, c.Del("key0", "key2", "key4", "key6", "key8"))
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if i%2 == 0 {
+			requireMiss(t, c, key)
+		} else {
+			s.requireValue(t, c, key, "value")
+		}
+	}
+}
+
+func (s *suite) testDelMissing(t *testing.T) {
+	c := s.f(t)
+
+	require.NoError(t, c.Del())
+	require.NoError(t, c.Del("missing"))
+	require.NoError(t, c.Set("key", "value"))
+	require.NoError(t, c.Del("key", "missing"))
+	requireMiss(t, c, "key")
+}
+
+func (s *suite) testConcurrent(t *testing.T) {
+	c := s.f(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64*3)
+	for i := 0; i < 64; i++ {
+		key := fmt.Sprintf("key%d", i%8)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := c.Set(key, "value"); err != nil {
+				errs <- err
+			}
+			if _, err := c.Get(key); err != nil && !errors.Is(err, cache.ErrCacheMiss) {
+				errs <- err
+			}
+			if err := c.Del(key, "shared"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// Every goroutine deleted its key after setting it,
+	// but another goroutine might have set it again in the meantime.
+	require.NoError(t, c.Del("key0", "key1", "key2", "key3", "key4", "key5", "key6", "key7"))
+	for i := 0; i < 8; i++ {
+		requireMiss(t, c, fmt.Sprintf("key%d", i))
+	}
+}
+
+func (s *suite) testContext(t *testing.T) {
+	c, ok := s.f(t).(cache.ContextCache)
+	if !ok {
+		t.Skip("cache does not implement cache.ContextCache")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, c.SetContext(ctx, "key", "value"))
+	v, err := c.GetContext(ctx, "key")
+	if !s.lossy || !errors.Is(err, cache.ErrCacheMiss) {
+		require.NoError(t, err)
+		require.Equal(t, "value", v)
+	}
+	require.NoError(t, c.DelContext(ctx, "key"))
+	_, err = c.GetContext(ctx, "key")
+	require.ErrorIs(t, err, cache.ErrCacheMiss)
+}
+
+func (s *suite) testTTL(t *testing.T) {
+	c := s.f(t)
+	tc, ok := c.(cache.TTLCache)
+	if !ok {
+		t.Skip("cache does not implement cache.TTLCache")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, tc.SetWithTTL(ctx, "expiring", "value", s.ttlResolution))
+	require.NoError(t, tc.SetWithTTL(ctx, "persistent", "value", 0))
+	s.requireValue(t, c, "expiring", "value")
+
+	time.Sleep(3 * s.ttlResolution)
+
+	requireMiss(t, c, "expiring")
+	s.requireValue(t, c, "persistent", "value")
+}
+
+// compare compares decimal numbers.
+func compare(a, b string) (int, error) {
+	ai, err := strconv.Atoi(a)
+	if err != nil {
+		return 0, err
+	}
+	bi, err := strconv.Atoi(b)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case ai < bi:
+		return -1, nil
+	case ai > bi:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (s *suite) testSetIfNewer(t *testing.T) {
+	c := s.f(t)
+	mc, ok := c.(cache.MonotonicCache)
+	if !ok {
+		t.Skip("cache does not implement cache.MonotonicCache")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, mc.SetIfNewer(ctx, "key", "2", 0, compare))
+	s.requireValue(t, c, "key", "2")
+	require.NoError(t, mc.SetIfNewer(ctx, "key", "1", 0, compare))
+	s.requireValue(t, c, "key", "2")
+	require.NoError(t, mc.SetIfNewer(ctx, "key", "10", 0, compare))
+	s.requireValue(t, c, "key", "10")
+
+	// Values that can not be compared are set.
+	require.NoError(t, mc.SetIfNewer(ctx, "key", "invalid", 0, compare))
+	s.requireValue(t, c, "key", "invalid")
+
+	if _, ok := c.(cache.TTLCache); !ok {
+		return
+	}
+	require.NoError(t, mc.SetIfNewer(ctx, "expiring", "1", s.ttlResolution, compare))
+	s.requireValue(t, c, "expiring", "1")
+	time.Sleep(3 * s.ttlResolution)
+	requireMiss(t, c, "expiring")
+}
+
+func (s *suite) testConcurrentSetIfNewer(t *testing.T) {
+	c := s.f(t)
+	mc, ok := c.(cache.MonotonicCache)
+	if !ok {
+		t.Skip("cache does not implement cache.MonotonicCache")
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 1; i <= 50; i++ {
+		value := strconv.Itoa(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := mc.SetIfNewer(ctx, "key", value, 0, compare); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	s.requireValue(t, c, "key", "50")
+}
+
+func (s *suite) testGetMulti(t *testing.T) {
+	c := s.f(t)
+	bc, ok := c.(cache.BatchCache)
+	if !ok {
+		t.Skip("cache does not implement cache.BatchCache")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, c.Set("a", "1"))
+	require.NoError(t, c.Set("b", ""))
+
+	v, err := bc.GetMulti(ctx, "a", "b", "missing")
+	require.NoError(t, err)
+	require.NotContains(t, v, "missing")
+	if s.lossy {
+		return
+	}
+	require.Equal(t, map[string]string{"a": "1", "b": ""}, v)
+
+	v, err = bc.GetMulti(ctx)
+	require.NoError(t, err)
+	require.Empty(t, v)
+}
+
+func (s *suite) testSetMulti(t *testing.T) {
+	c := s.f(t)
+	bc, ok := c.(cache.BatchCache)
+	if !ok {
+		t.Skip("cache does not implement cache.BatchCache")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, c.Set("a", "old"))
+	require.NoError(t, bc.SetMulti(ctx, map[string]string{"a": "1", "b": "2"}, 0, nil))
+	s.requireValue(t, c, "a", "1")
+	s.requireValue(t, c, "b", "2")
+	require.NoError(t, bc.SetMulti(ctx, nil, 0, nil))
+
+	require.NoError(t, bc.SetMulti(ctx, map[string]string{"expiring": "1"}, s.ttlResolution, nil))
+	s.requireValue(t, c, "expiring", "1")
+	time.Sleep(3 * s.ttlResolution)
+	requireMiss(t, c, "expiring")
+}
+
+func (s *suite) testSetMultiIfNewer(t *testing.T) {
+	c := s.f(t)
+	bc, ok := c.(cache.BatchCache)
+	if !ok {
+		t.Skip("cache does not implement cache.BatchCache")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, c.Set("older", "1"))
+	require.NoError(t, c.Set("newer", "10"))
+	require.NoError(t, c.Set("invalid", "invalid"))
+	require.NoError(t, bc.SetMulti(ctx, map[string]string{"older": "5", "newer": "5", "invalid": "5", "missing": "5"}, 0, compare))
+	s.requireValue(t, c, "older", "5")
+	s.requireValue(t, c, "newer", "10")
+	// Values that can not be compared are set.
+	s.requireValue(t, c, "invalid", "5")
+	s.requireValue(t, c, "missing", "5")
+}
@@ -1,20 +1,30 @@
 package gocache
 
 import (
-	"github.com/hashicorp/go-multierror"
+	"context"
+	"sync"
+	"time"
+
 	gcache "github.com/patrickmn/go-cache"
 
 	"github.com/connylabs/zedcache/cache"
 )
 
-var _ cache.Cache = &Cache{}
+var (
+	_ cache.Cache          = &Cache{}
+	_ cache.ContextCache   = &Cache{}
+	_ cache.MonotonicCache = &Cache{}
+	_ cache.TTLCache       = &Cache{}
+)
 
 func New(c *gcache.Cache) *Cache {
-	return &Cache{c}
+	return &Cache{c: c}
 }
 
 type Cache struct {
 	c *gcache.Cache
+	// mu serializes writes, so that SetIfNewer can not interleave with other writes.
+	mu sync.Mutex
 }
 
 func (c *Cache) Get(key string) (string, error) {
@@ -30,23 +40,82 @@ func (c *Cache) Get(key string) (string, error) {
 }
 
 func (c *Cache) Set(key, value string) error {
-	c.c.Set(key, value, gcache.NoExpiration)
+	return c.set(key, value, 0)
+}
+
+func (c *Cache) set(key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.c.Set(key, value, expiration(ttl))
 
 	return nil
 }
 
+// expiration converts a ttl to go-cache's expiration, for which 0 means the cache's default expiration.
+func expiration(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return gcache.NoExpiration
+	}
+
+	return ttl
+}
+
 func (c *Cache) Del(keys ...string) error {
-	g := multierror.Group{}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	for _, k := range keys {
-		key := k
+		c.c.Delete(k)
+	}
+
+	return nil
+}
+
+func (c *Cache) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return c.Get(key)
+}
+
+func (c *Cache) SetContext(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Set(key, value)
+}
+
+func (c *Cache) DelContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Del(keys...)
+}
+
+func (c *Cache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.set(key, value, ttl)
+}
+
+func (c *Cache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-		g.Go(func() error {
-			c.c.Delete(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			return nil
-		})
+	if old, err := c.Get(key); err == nil && !cache.IsNewer(value, old, cmp) {
+		return nil
 	}
+	c.c.Set(key, value, expiration(ttl))
 
-	return g.Wait().ErrorOrNil()
+	return nil
 }
@@ -0,0 +1,285 @@
+// Package lru implements a bounded in-memory cache.Cache that evicts the least recently used entries.
+package lru
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// DefaultMaxEntries is the number of entries a Cache holds if neither WithMaxEntries nor WithMaxBytes is given.
+const DefaultMaxEntries = 1 << 20
+
+// Admission decides whether a new entry is added to a full Cache.
+type Admission int
+
+const (
+	// LRU always adds new entries and evicts the least recently used ones.
+	LRU Admission = iota
+	// TinyLFU only adds a new entry to a full Cache, if its key was used more frequently than the key of the least recently used entry.
+	// The frequencies are estimated with a count-min sketch, so that keys that are only used once can not evict frequently used ones.
+	TinyLFU
+)
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// WithMaxEntries limits the number of entries.
+func WithMaxEntries(n int) Option {
+	return func(c *Cache) {
+		c.maxEntries = n
+	}
+}
+
+// WithMaxBytes limits the total size of the keys and values of all entries.
+func WithMaxBytes(n int64) Option {
+	return func(c *Cache) {
+		c.maxBytes = n
+	}
+}
+
+// WithAdmission sets the Admission policy. The default is LRU.
+func WithAdmission(a Admission) Option {
+	return func(c *Cache) {
+		c.admission = a
+	}
+}
+
+// WithDefaultTTL sets the time after which entries that are set without a ttl expire.
+// By default these entries do not expire.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+var (
+	_ cache.Cache          = &Cache{}
+	_ cache.ContextCache   = &Cache{}
+	_ cache.MonotonicCache = &Cache{}
+	_ cache.TTLCache       = &Cache{}
+)
+
+type entry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// Cache is an in-memory cache with a bounded number of entries or bytes.
+// If the Cache is full, the least recently used entries are evicted.
+// Expired entries are removed when they are looked up or evicted.
+type Cache struct {
+	maxEntries int
+	maxBytes   int64
+	admission  Admission
+	defaultTTL time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+	s     *sketch
+}
+
+// New creates a Cache.
+func New(opts ...Option) *Cache {
+	c := &Cache{
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	if c.maxEntries <= 0 && c.maxBytes <= 0 {
+		c.maxEntries = DefaultMaxEntries
+	}
+	if c.admission == TinyLFU {
+		c.s = newSketch(c.capacity())
+	}
+
+	return c
+}
+
+// capacity estimates the number of entries the Cache can hold.
+func (c *Cache) capacity() int {
+	if c.maxEntries > 0 {
+		return c.maxEntries
+	}
+	// Assume entries of 64 bytes, which is roughly the size of a key and a zedtoken.
+	return int(c.maxBytes / 64)
+}
+
+// Len returns the number of entries, including expired ones that were not removed yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *Cache) Get(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.get(key)
+	if !ok {
+		return "", cache.ErrCacheMiss
+	}
+
+	return e.value, nil
+}
+
+// get returns the entry of the key and marks it as recently used.
+func (c *Cache) get(key string) (*entry, bool) {
+	if c.s != nil {
+		c.s.increment(key)
+	}
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+
+	return e, true
+}
+
+func (c *Cache) Set(key, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, 0)
+
+	return nil
+}
+
+// set adds or updates the entry of the key and evicts the least recently used entries until the Cache is not full anymore.
+// If the entry does not fit into the Cache or is not admitted, it is not added.
+func (c *Cache) set(key, value string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = c.defaultTTL
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		c.bytes += int64(len(value) - len(e.value))
+		e.value = value
+		e.expires = expires
+		c.ll.MoveToFront(el)
+		c.evict()
+		return
+	}
+
+	e := &entry{key: key, value: value, expires: expires}
+	if c.maxBytes > 0 && e.size() > c.maxBytes {
+		return
+	}
+	if c.s != nil && c.full(e.size()) {
+		if victim := c.ll.Back(); victim != nil && c.s.estimate(key) <= c.s.estimate(victim.Value.(*entry).key) {
+			return
+		}
+	}
+	c.items[key] = c.ll.PushFront(e)
+	c.bytes += e.size()
+	c.evict()
+}
+
+// full reports whether an entry of the given size can only be added by evicting others.
+func (c *Cache) full(size int64) bool {
+	return (c.maxEntries > 0 && c.ll.Len() >= c.maxEntries) || (c.maxBytes > 0 && c.bytes+size > c.maxBytes)
+}
+
+// evict removes the least recently used entries until the Cache holds at most the maximum number of entries and bytes.
+func (c *Cache) evict() {
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.bytes -= e.size()
+}
+
+func (c *Cache) Del(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range keys {
+		if el, ok := c.items[k]; ok {
+			c.remove(el)
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return c.Get(key)
+}
+
+func (c *Cache) SetContext(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Set(key, value)
+}
+
+func (c *Cache) DelContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Del(keys...)
+}
+
+func (c *Cache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, ttl)
+
+	return nil
+}
+
+func (c *Cache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.get(key); ok && !cache.IsNewer(value, e.value, cmp) {
+		return nil
+	}
+	c.set(key, value, ttl)
+
+	return nil
+}
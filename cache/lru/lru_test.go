@@ -0,0 +1,124 @@
+package lru
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/cachetest"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("lru", func(t *testing.T) {
+		cachetest.Run(t, func(t *testing.T) cache.Cache {
+			return New()
+		})
+	})
+
+	t.Run("tinylfu", func(t *testing.T) {
+		cachetest.Run(t, func(t *testing.T) cache.Cache {
+			return New(WithAdmission(TinyLFU), WithMaxBytes(64<<20))
+		})
+	})
+}
+
+func requireKeys(t *testing.T, c *Cache, keys ...string) {
+	t.Helper()
+
+	for _, k := range keys {
+		_, err := c.Get(k)
+		require.NoError(t, err, k)
+	}
+}
+
+func requireMissing(t *testing.T, c *Cache, keys ...string) {
+	t.Helper()
+
+	for _, k := range keys {
+		_, err := c.Get(k)
+		require.ErrorIs(t, err, cache.ErrCacheMiss, k)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	t.Run("max entries", func(t *testing.T) {
+		c := New(WithMaxEntries(2))
+		require.NoError(t, c.Set("a", "1"))
+		require.NoError(t, c.Set("b", "2"))
+		// a is now used more recently than b.
+		requireKeys(t, c, "a")
+		require.NoError(t, c.Set("c", "3"))
+
+		assert.Equal(t, 2, c.Len())
+		requireMissing(t, c, "b")
+		requireKeys(t, c, "a", "c")
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		c := New(WithMaxBytes(10))
+		require.NoError(t, c.Set("a", "1234"))
+		require.NoError(t, c.Set("b", "1234"))
+		require.NoError(t, c.Set("c", "1234"))
+		requireMissing(t, c, "a")
+		requireKeys(t, c, "b", "c")
+
+		// Growing an entry evicts others.
+		require.NoError(t, c.Set("c", "123456789"))
+		requireMissing(t, c, "b")
+		requireKeys(t, c, "c")
+
+		// Entries that exceed the budget are not added.
+		require.NoError(t, c.Set("d", "1234567890"))
+		requireMissing(t, c, "d")
+		requireKeys(t, c, "c")
+	})
+
+	t.Run("default ttl", func(t *testing.T) {
+		c := New(WithDefaultTTL(50 * time.Millisecond))
+		require.NoError(t, c.Set("a", "1"))
+		requireKeys(t, c, "a")
+		time.Sleep(150 * time.Millisecond)
+		requireMissing(t, c, "a")
+		assert.Equal(t, 0, c.Len())
+	})
+
+	t.Run("tinylfu", func(t *testing.T) {
+		c := New(WithMaxEntries(10), WithAdmission(TinyLFU))
+		for i := 0; i < 10; i++ {
+			k := strconv.Itoa(i)
+			require.NoError(t, c.Set(k, k))
+			for j := 0; j < 3; j++ {
+				requireKeys(t, c, k)
+			}
+		}
+
+		// Keys that are used once do not evict frequently used ones.
+		for i := 10; i < 100; i++ {
+			k := strconv.Itoa(i)
+			_, err := c.Get(k)
+			require.ErrorIs(t, err, cache.ErrCacheMiss)
+			require.NoError(t, c.Set(k, k))
+		}
+		for i := 0; i < 10; i++ {
+			requireKeys(t, c, strconv.Itoa(i))
+		}
+
+		// Keys that are used frequently are admitted.
+		for j := 0; j < 10; j++ {
+			_, _ = c.Get("new")
+		}
+		require.NoError(t, c.Set("new", "1"))
+		requireKeys(t, c, "new")
+		assert.Equal(t, 10, c.Len())
+	})
+}
+
+func BenchmarkCache(b *testing.B) {
+	cachetest.Benchmark(b, func(b *testing.B) cache.Cache {
+		return New()
+	})
+}
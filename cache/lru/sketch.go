@@ -0,0 +1,96 @@
+package lru
+
+import (
+	"hash/maphash"
+)
+
+const (
+	// depth is the number of counters per key.
+	depth = 4
+	// maxCount is the value at which counters saturate.
+	maxCount = 15
+)
+
+// sketch is a count-min sketch that estimates how often keys were used.
+// All counters are halved once the number of increments reaches ten times the width,
+// so that the frequency of keys that are not used anymore decays.
+type sketch struct {
+	seed     maphash.Seed
+	counters [depth][]uint8
+	mask     uint64
+	count    int
+	reset    int
+}
+
+func newSketch(capacity int) *sketch {
+	// Small sketches overestimate the frequencies of most keys because of collisions.
+	width := 1024
+	for width < capacity && width < 1<<24 {
+		width <<= 1
+	}
+	s := &sketch{
+		seed:  maphash.MakeSeed(),
+		mask:  uint64(width - 1),
+		reset: 10 * width,
+	}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, width)
+	}
+
+	return s
+}
+
+// indexes returns the index of the key's counter in every row.
+func (s *sketch) indexes(key string) [depth]uint64 {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	h.WriteString(key)
+	sum := h.Sum64()
+	var idx [depth]uint64
+	for i := range idx {
+		sum = mix(sum)
+		idx[i] = sum & s.mask
+	}
+
+	return idx
+}
+
+// mix is the finalizer of splitmix64. It derives an independent hash for every row from the hash of the key.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+
+	return x ^ (x >> 31)
+}
+
+func (s *sketch) increment(key string) {
+	for i, j := range s.indexes(key) {
+		if s.counters[i][j] < maxCount {
+			s.counters[i][j]++
+		}
+	}
+	if s.count++; s.count >= s.reset {
+		s.halve()
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	min := uint8(maxCount)
+	for i, j := range s.indexes(key) {
+		if c := s.counters[i][j]; c < min {
+			min = c
+		}
+	}
+
+	return min
+}
+
+func (s *sketch) halve() {
+	for i := range s.counters {
+		for j := range s.counters[i] {
+			s.counters[i][j] >>= 1
+		}
+	}
+	s.count /= 2
+}
@@ -1,7 +1,9 @@
 package memcached
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	gmc "github.com/bradfitz/gomemcache/memcache"
 	"github.com/hashicorp/go-multierror"
@@ -9,11 +11,39 @@ import (
 	"github.com/connylabs/zedcache/cache"
 )
 
+// MemCache is a cache.Cache backed by memcached.
+// The client does not support contexts, so the latency of
+// all operations is bounded by the Client's Timeout instead.
 type MemCache struct {
 	Client *gmc.Client
 }
 
-var _ cache.Cache = &MemCache{}
+var (
+	_ cache.BatchCache     = &MemCache{}
+	_ cache.Cache          = &MemCache{}
+	_ cache.ContextCache   = &MemCache{}
+	_ cache.MonotonicCache = &MemCache{}
+	_ cache.TTLCache       = &MemCache{}
+)
+
+// maxRelativeExpiration is the longest expiration that memcached interprets relative to the current time.
+// Longer expirations must be given as a unix timestamp.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// expiration converts a ttl to memcached's expiration.
+func expiration(ttl time.Duration) int32 {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl > maxRelativeExpiration:
+		return int32(time.Now().Add(ttl).Unix())
+	case ttl < time.Second:
+		// memcached has a resolution of one second and 0 would never expire.
+		return 1
+	default:
+		return int32(ttl / time.Second)
+	}
+}
 
 func (mc *MemCache) Get(k string) (string, error) {
 	v, err := mc.Client.Get(k)
@@ -29,10 +59,14 @@ func (mc *MemCache) Get(k string) (string, error) {
 }
 
 func (mc *MemCache) Set(k string, v string) error {
+	return mc.set(k, v, 0)
+}
+
+func (mc *MemCache) set(k string, v string, ttl time.Duration) error {
 	return mc.Client.Set(&gmc.Item{
 		Key:        k,
 		Value:      []byte(v),
-		Expiration: 0,
+		Expiration: expiration(ttl),
 	})
 }
 
@@ -54,3 +88,154 @@ func (mc *MemCache) Del(keys ...string) error {
 
 	return g.Wait().ErrorOrNil()
 }
+
+func (mc *MemCache) GetContext(ctx context.Context, k string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return mc.Get(k)
+}
+
+func (mc *MemCache) SetContext(ctx context.Context, k string, v string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return mc.Set(k, v)
+}
+
+func (mc *MemCache) DelContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return mc.Del(keys...)
+}
+
+func (mc *MemCache) SetWithTTL(ctx context.Context, k string, v string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return mc.set(k, v, ttl)
+}
+
+// SetIfNewer uses memcached's CAS to replace the cached value,
+// if it did not change since it was compared. Otherwise the comparison is retried.
+func (mc *MemCache) SetIfNewer(ctx context.Context, k string, v string, ttl time.Duration, cmp cache.Compare) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		item, err := mc.Client.Get(k)
+		if errors.Is(err, gmc.ErrCacheMiss) {
+			err = mc.Client.Add(&gmc.Item{
+				Key:        k,
+				Value:      []byte(v),
+				Expiration: expiration(ttl),
+			})
+			if errors.Is(err, gmc.ErrNotStored) {
+				continue
+			}
+
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if !cache.IsNewer(v, string(item.Value), cmp) {
+			return nil
+		}
+
+		item.Value = []byte(v)
+		item.Expiration = expiration(ttl)
+		err = mc.Client.CompareAndSwap(item)
+		if errors.Is(err, gmc.ErrCASConflict) || errors.Is(err, gmc.ErrNotStored) {
+			continue
+		}
+
+		return err
+	}
+}
+
+// GetMulti looks up all keys with a single request per memcached server.
+func (mc *MemCache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	items, err := mc.Client.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(items))
+	for k, item := range items {
+		values[k] = string(item.Value)
+	}
+
+	return values, nil
+}
+
+// maxConcurrentSets limits the number of concurrent requests of SetMulti.
+const maxConcurrentSets = 16
+
+// SetMulti sets the keys with a bounded number of concurrent requests, because memcached's text protocol has no command to set multiple keys.
+// If cmp is not nil, the cached values are read with a single GetMulti and every newer value is set with CAS,
+// or added if the key is missing. Keys that changed in the meantime are compared again like with SetIfNewer.
+func (mc *MemCache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var items map[string]*gmc.Item
+	if cmp != nil {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		var err error
+		if items, err = mc.Client.GetMulti(keys); err != nil {
+			return err
+		}
+	}
+
+	sem := make(chan struct{}, maxConcurrentSets)
+	g := multierror.Group{}
+	for k, v := range values {
+		key, value := k, v
+		item, ok := items[key]
+		if ok && !cache.IsNewer(value, string(item.Value), cmp) {
+			continue
+		}
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+
+			if cmp == nil {
+				return mc.set(key, value, ttl)
+			}
+			return mc.swap(ctx, item, key, value, ttl, cmp)
+		})
+	}
+
+	return g.Wait().ErrorOrNil()
+}
+
+// swap replaces the item that was read before with value, or adds value if item is nil.
+// If the key changed since the item was read, the comparison is retried with SetIfNewer.
+func (mc *MemCache) swap(ctx context.Context, item *gmc.Item, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	var err error
+	if item == nil {
+		err = mc.Client.Add(&gmc.Item{Key: key, Value: []byte(value), Expiration: expiration(ttl)})
+	} else {
+		item.Value = []byte(value)
+		item.Expiration = expiration(ttl)
+		err = mc.Client.CompareAndSwap(item)
+	}
+	if errors.Is(err, gmc.ErrNotStored) || errors.Is(err, gmc.ErrCASConflict) {
+		return mc.SetIfNewer(ctx, key, value, ttl, cmp)
+	}
+
+	return err
+}
@@ -7,20 +7,23 @@ import (
 
 	gmc "github.com/bradfitz/gomemcache/memcache"
 	"github.com/efficientgo/e2e"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/cachetest"
 )
 
-func TestCache(t *testing.T) {
+// startMemcached starts a memcached server in Docker, if the E2E environment variable is set.
+func startMemcached(tb testing.TB) e2e.Runnable {
+	tb.Helper()
+
 	if v, ok := os.LookupEnv("E2E"); !ok || !(v == "1" || v == "true") {
-		t.Skip("To enable this test, set the E2E environment variable to 1 or true")
+		tb.Skip("To enable this test, set the E2E environment variable to 1 or true")
 	}
 
 	e, err := e2e.NewDockerEnvironment("memcache-e2e")
-	require.NoError(t, err)
-	require.NoError(t, err)
+	require.NoError(tb, err)
+	tb.Cleanup(e.Close)
 	r := e.Runnable("memcache").WithPorts(
 		map[string]int{
 			"memcache": 11211,
@@ -28,65 +31,35 @@ func TestCache(t *testing.T) {
 		Image:     "memcached:1.6-alpine",
 		Readiness: e2e.NewTCPReadinessProbe("memcache"),
 	})
-	require.NoError(t, r.Start())
-	require.NoError(t, r.WaitReady())
-
-	t.Run("miss", func(t *testing.T) {
-		c := connection(t, r)
-		_, err := c.Get("key")
-		assert.ErrorIs(t, err, cache.ErrCacheMiss)
-	})
-
-	t.Run("hit", func(t *testing.T) {
-		c := connection(t, r)
-
-		assert.NoError(t, c.Set("key", "value"))
-		time.Sleep(time.Second)
-
-		v, err := c.Get("key")
-
-		assert.NoError(t, err)
-
-		assert.Equal(t, "value", v)
-	})
+	require.NoError(tb, r.Start())
+	require.NoError(tb, r.WaitReady())
 
-	t.Run("del", func(t *testing.T) {
-		c := connection(t, r)
-
-		assert.NoError(t, c.Set("key", "value"))
-		time.Sleep(time.Second)
-
-		_, err := c.Get("key")
-
-		assert.NoError(t, err)
-
-		assert.NoError(t, c.Del("key"))
-
-		_, err = c.Get("key")
-		assert.ErrorIs(t, err, cache.ErrCacheMiss)
-	})
-
-	t.Run("hit after overwrite", func(t *testing.T) {
-		c := connection(t, r)
+	return r
+}
 
-		assert.NoError(t, c.Set("key", "value"))
-		assert.NoError(t, c.Set("key", "value!"))
-		time.Sleep(time.Second)
+func TestCache(t *testing.T) {
+	r := startMemcached(t)
 
-		v, err := c.Get("key")
+	// memcached expires entries with a resolution of one second.
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return connection(t, r)
+	}, cachetest.TTLResolution(time.Second))
+}
 
-		assert.NoError(t, err)
+func BenchmarkCache(b *testing.B) {
+	r := startMemcached(b)
 
-		assert.Equal(t, "value!", v)
+	cachetest.Benchmark(b, func(b *testing.B) cache.Cache {
+		return connection(b, r)
 	})
 }
 
-func connection(t *testing.T, r e2e.Runnable) *MemCache {
-	t.Helper()
+func connection(tb testing.TB, r e2e.Runnable) *MemCache {
+	tb.Helper()
 
 	conn := gmc.New(r.Endpoint("memcache"))
-	t.Cleanup(func() {
-		require.NoError(t, conn.DeleteAll())
+	tb.Cleanup(func() {
+		require.NoError(tb, conn.DeleteAll())
 	})
 	return &MemCache{conn}
 }
@@ -1,10 +1,20 @@
 package noopcache
 
-import "github.com/connylabs/zedcache/cache"
+import (
+	"context"
+	"time"
+
+	"github.com/connylabs/zedcache/cache"
+)
 
 type Noopcache struct{}
 
-var _ cache.Cache = &Noopcache{}
+var (
+	_ cache.Cache          = &Noopcache{}
+	_ cache.ContextCache   = &Noopcache{}
+	_ cache.MonotonicCache = &Noopcache{}
+	_ cache.TTLCache       = &Noopcache{}
+)
 
 func (*Noopcache) Get(string) (string, error) {
 	return "", cache.ErrCacheMiss
@@ -17,3 +27,23 @@ func (*Noopcache) Set(string, string) error {
 func (*Noopcache) Del(...string) error {
 	return nil
 }
+
+func (*Noopcache) GetContext(context.Context, string) (string, error) {
+	return "", cache.ErrCacheMiss
+}
+
+func (*Noopcache) SetContext(context.Context, string, string) error {
+	return nil
+}
+
+func (*Noopcache) DelContext(context.Context, ...string) error {
+	return nil
+}
+
+func (*Noopcache) SetWithTTL(context.Context, string, string, time.Duration) error {
+	return nil
+}
+
+func (*Noopcache) SetIfNewer(context.Context, string, string, time.Duration, cache.Compare) error {
+	return nil
+}
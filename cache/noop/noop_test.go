@@ -0,0 +1,14 @@
+package noopcache
+
+import (
+	"testing"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/cachetest"
+)
+
+func TestCache(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return &Noopcache{}
+	}, cachetest.Lossy())
+}
@@ -0,0 +1,243 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// GetMulti looks up all keys with a single MGET.
+// In a Redis Cluster the keys are grouped by the node that serves them and every node is asked with a single pipeline,
+// because the keys of an MGET must belong to the same slot.
+func (c *Cache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	if c.cluster != nil {
+		if err := c.getCluster(ctx, keys, values); err != nil {
+			return nil, err
+		}
+
+		return values, nil
+	}
+
+	conn, err := c.p.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := mget(ctx, conn, keys, values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// getCluster pipelines one GET per key to every node that serves some of the keys and adds the cached values to values.
+// Keys whose lookup was redirected by the cluster are looked up one by one.
+func (c *Cache) getCluster(ctx context.Context, keys []string, values map[string]string) error {
+	nodes, err := c.nodes(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	g := multierror.Group{}
+	for _, ks := range nodes {
+		ks := ks
+		g.Go(func() error {
+			conn, err := c.cluster.GetContext(ctx, ks[0])
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			for _, k := range ks {
+				if err := conn.Send("GET", k); err != nil {
+					return err
+				}
+			}
+			if err := conn.Flush(); err != nil {
+				return err
+			}
+			found := make(map[string]string, len(ks))
+			var redirected []string
+			for _, k := range ks {
+				v, err := redis.String(redis.ReceiveContext(conn, ctx))
+				if _, _, _, ok := parseRedirect(err); ok {
+					redirected = append(redirected, k)
+					continue
+				}
+				if errors.Is(err, redis.ErrNil) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to get cached entry for key %q: %w", k, err)
+				}
+				found[k] = v
+			}
+			for _, k := range redirected {
+				v, err := redis.String(redis.DoContext(conn, ctx, "GET", k))
+				if errors.Is(err, redis.ErrNil) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to get cached entry for key %q: %w", k, err)
+				}
+				found[k] = v
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for k, v := range found {
+				values[k] = v
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait().ErrorOrNil()
+}
+
+// mget adds the cached values of the keys to values.
+func mget(ctx context.Context, conn redis.Conn, keys []string, values map[string]string) error {
+	args := make([]interface{}, len(keys))
+	for i := range keys {
+		args[i] = keys[i]
+	}
+	reply, err := redis.Values(redis.DoContext(conn, ctx, "MGET", args...))
+	if err != nil {
+		return fmt.Errorf("failed to get cached entries: %w", err)
+	}
+	for i, r := range reply {
+		if r == nil {
+			continue
+		}
+		v, err := redis.String(r, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get cached entry for key %q: %w", keys[i], err)
+		}
+		values[keys[i]] = v
+	}
+
+	return nil
+}
+
+// SetMulti pipelines the writes of all keys.
+// If cmp is not nil, the cached values are read with a single MGET and
+// every newer value is set with the same Lua script as SetIfNewer. Keys that changed in the meantime are retried.
+// In a Redis Cluster the keys are set concurrently one by one.
+func (c *Cache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	if len(values) == 0 {
+		return nil
+	}
+	if c.cluster != nil {
+		g := multierror.Group{}
+		for k, v := range values {
+			key, value := k, v
+			g.Go(func() error {
+				if cmp == nil {
+					return c.SetWithTTL(ctx, key, value, ttl)
+				}
+				return c.SetIfNewer(ctx, key, value, ttl, cmp)
+			})
+		}
+
+		return g.Wait().ErrorOrNil()
+	}
+
+	conn, err := c.p.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if cmp == nil {
+		for k, v := range values {
+			args := []interface{}{k, v}
+			if ttl > 0 {
+				args = append(args, "PX", milliseconds(ttl))
+			}
+			if err := conn.Send("SET", args...); err != nil {
+				return err
+			}
+		}
+		_, err := receiveAll(ctx, conn, len(values))
+
+		return err
+	}
+
+	pending := values
+	for len(pending) > 0 {
+		keys := make([]string, 0, len(pending))
+		for k := range pending {
+			keys = append(keys, k)
+		}
+		old := make(map[string]string, len(keys))
+		if err := mget(ctx, conn, keys, old); err != nil {
+			return err
+		}
+
+		var sent []string
+		for _, k := range keys {
+			o, ok := old[k]
+			if ok && !cache.IsNewer(pending[k], o, cmp) {
+				continue
+			}
+			flag := "0"
+			if !ok {
+				flag = "1"
+			}
+			if err := compareAndSet.Send(conn, k, o, pending[k], flag, milliseconds(ttl)); err != nil {
+				return err
+			}
+			sent = append(sent, k)
+		}
+		if len(sent) == 0 {
+			return nil
+		}
+		replies, err := receiveAll(ctx, conn, len(sent))
+		if err != nil {
+			return fmt.Errorf("failed to set cached entries: %w", err)
+		}
+
+		next := make(map[string]string)
+		for i, k := range sent {
+			if set, _ := redis.Bool(replies[i], nil); !set {
+				next[k] = pending[k]
+			}
+		}
+		pending = next
+	}
+
+	return nil
+}
+
+// receiveAll flushes the connection and receives n replies.
+// All replies are received, even if one of them is an error, so that the connection can be reused.
+func receiveAll(ctx context.Context, conn redis.Conn, n int) ([]interface{}, error) {
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, n)
+	var first error
+	for i := range replies {
+		r, err := redis.ReceiveContext(conn, ctx)
+		if err != nil && first == nil {
+			first = err
+		}
+		replies[i] = r
+	}
+
+	return replies, first
+}
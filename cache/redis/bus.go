@@ -0,0 +1,80 @@
+package rediscache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/connylabs/zedcache/cache/broadcast"
+)
+
+// DefaultChannel is the Pub/Sub channel that a Bus uses by default.
+const DefaultChannel = "zedcache:invalidations"
+
+var _ broadcast.Bus = &Bus{}
+
+// Bus is a broadcast.Bus that uses Redis Pub/Sub.
+type Bus struct {
+	p       Pool
+	channel string
+}
+
+// NewBus creates a Bus that publishes and subscribes with connections from the given pool.
+// A subscription holds a connection of the pool until it ends.
+// If channel is empty, DefaultChannel is used.
+func NewBus(p Pool, channel string) *Bus {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+
+	return &Bus{p: p, channel: channel}
+}
+
+func (b *Bus) Publish(ctx context.Context, keys ...string) error {
+	msg, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	conn, err := b.p.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = redis.DoContext(conn, ctx, "PUBLISH", b.channel, msg)
+
+	return err
+}
+
+func (b *Bus) Subscribe(ctx context.Context, ready func(), f func(keys ...string)) error {
+	conn, err := b.p.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(b.channel); err != nil {
+		return err
+	}
+	for {
+		switch v := psc.ReceiveContext(ctx).(type) {
+		case redis.Subscription:
+			if v.Kind == "subscribe" {
+				ready()
+			}
+		case redis.Message:
+			var keys []string
+			// A message that can not be decoded could have invalidated any key,
+			// so the subscription is ended and all cached values are discarded.
+			if err := json.Unmarshal(v.Data, &keys); err != nil {
+				return fmt.Errorf("failed to decode message: %w", err)
+			}
+			f(keys...)
+		case error:
+			return v
+		}
+	}
+}
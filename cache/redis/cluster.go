@@ -0,0 +1,308 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	hashSlots    = 16384
+	maxRedirects = 5
+)
+
+// Cluster routes commands to the nodes of a Redis Cluster according to the hash slot of their key.
+type Cluster struct {
+	// Addrs are the addresses of the nodes that are used to discover the topology of the cluster.
+	Addrs []string
+	// NewPool creates a connection pool for the node with the given address.
+	// If nil, a pool with plain TCP connections is used.
+	NewPool func(addr string) *redis.Pool
+
+	mu    sync.RWMutex
+	slots []string
+	pools map[string]*redis.Pool
+}
+
+// Refresh loads the mapping of hash slots to nodes from the first node that answers.
+func (c *Cluster) Refresh(ctx context.Context) error {
+	c.mu.RLock()
+	addrs := append([]string(nil), c.Addrs...)
+	for addr := range c.pools {
+		addrs = append(addrs, addr)
+	}
+	c.mu.RUnlock()
+
+	var errs error
+	for _, addr := range addrs {
+		slots, err := c.loadSlots(ctx, addr)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("node %q: %w", addr, err))
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+
+		return nil
+	}
+	if errs == nil {
+		errs = errors.New("no cluster addresses configured")
+	}
+
+	return fmt.Errorf("failed to load cluster slots: %w", errs)
+}
+
+func (c *Cluster) loadSlots(ctx context.Context, addr string) ([]string, error) {
+	conn, err := c.pool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(redis.DoContext(conn, ctx, "CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]string, hashSlots)
+	for _, r := range ranges {
+		// Every range is an array of the first slot, the last slot and the master followed by its replicas.
+		info, err := redis.Values(r, nil)
+		if err != nil || len(info) < 3 {
+			return nil, fmt.Errorf("unexpected slot range %v", r)
+		}
+		start, err := redis.Int(info[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		end, err := redis.Int(info[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		master, err := redis.Values(info[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, fmt.Errorf("unexpected node %v", info[2])
+		}
+		host, err := redis.String(master[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		port, err := redis.Int(master[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			// An empty host means that the node is reachable with the same host as the one that was asked.
+			host, _, _ = net.SplitHostPort(addr)
+		}
+		if start < 0 || end >= hashSlots || start > end {
+			return nil, fmt.Errorf("invalid slot range %d-%d", start, end)
+		}
+		for s := start; s <= end; s++ {
+			slots[s] = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+
+	return slots, nil
+}
+
+// pool returns the connection pool of the node with the given address.
+func (c *Cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	p, ok := c.pools[addr]
+	c.mu.RUnlock()
+	if ok {
+		return p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p, ok := c.pools[addr]; ok {
+		return p
+	}
+	if c.pools == nil {
+		c.pools = make(map[string]*redis.Pool)
+	}
+	if c.NewPool != nil {
+		p = c.NewPool(addr)
+	} else {
+		p = &redis.Pool{
+			MaxIdle:     10,
+			IdleTimeout: 5 * time.Minute,
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return defaultDial(ctx, addr)
+			},
+		}
+	}
+	c.pools[addr] = p
+
+	return p
+}
+
+// addr returns the address of the node that serves the given key.
+func (c *Cluster) addr(ctx context.Context, key string) (string, error) {
+	slot := Slot(key)
+
+	c.mu.RLock()
+	loaded := c.slots != nil
+	var addr string
+	if loaded {
+		addr = c.slots[slot]
+	}
+	c.mu.RUnlock()
+	if addr != "" {
+		return addr, nil
+	}
+
+	if err := c.Refresh(ctx); err != nil {
+		return "", err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if addr = c.slots[slot]; addr == "" {
+		return "", fmt.Errorf("no node serves slot %d", slot)
+	}
+
+	return addr, nil
+}
+
+// GetContext returns a connection to the node that serves the given key.
+// Commands that are redirected by the cluster with MOVED or ASK are retried on the node they were redirected to.
+func (c *Cluster) GetContext(ctx context.Context, key string) (redis.Conn, error) {
+	addr, err := c.addr(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.pool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clusterConn{Conn: conn, c: c}, nil
+}
+
+// Close closes the connection pools of all nodes.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs error
+	for _, p := range c.pools {
+		if err := p.Close(); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	c.pools = nil
+
+	return errs
+}
+
+// clusterConn follows the redirections of a Redis Cluster for commands sent with Do.
+// Pipelined commands are not redirected.
+type clusterConn struct {
+	redis.Conn
+
+	c *Cluster
+}
+
+func (cc *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return cc.DoContext(context.Background(), cmd, args...)
+}
+
+func (cc *clusterConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := redis.DoContext(cc.Conn, ctx, cmd, args...)
+	for i := 0; i < maxRedirects; i++ {
+		asking, slot, addr, ok := parseRedirect(err)
+		if !ok {
+			return reply, err
+		}
+		if !asking {
+			cc.c.mu.Lock()
+			if cc.c.slots != nil {
+				cc.c.slots[slot] = addr
+			}
+			cc.c.mu.Unlock()
+		}
+		reply, err = cc.c.redirect(ctx, addr, asking, cmd, args...)
+	}
+
+	return reply, err
+}
+
+func (cc *clusterConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redis.ReceiveContext(cc.Conn, ctx)
+}
+
+// redirect runs the command on the node with the given address.
+func (c *Cluster) redirect(ctx context.Context, addr string, asking bool, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := c.pool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if asking {
+		if _, err := redis.DoContext(conn, ctx, "ASKING"); err != nil {
+			return nil, err
+		}
+	}
+
+	return redis.DoContext(conn, ctx, cmd, args...)
+}
+
+// parseRedirect parses MOVED and ASK errors, e.g. "MOVED 3999 127.0.0.1:6381".
+func parseRedirect(err error) (asking bool, slot int, addr string, ok bool) {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return false, 0, "", false
+	}
+	parts := strings.Fields(string(rerr))
+	if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+		return false, 0, "", false
+	}
+	slot, err = strconv.Atoi(parts[1])
+	if err != nil || slot < 0 || slot >= hashSlots {
+		return false, 0, "", false
+	}
+
+	return parts[0] == "ASK", slot, parts[2], true
+}
+
+// Slot returns the hash slot of the given key.
+// If the key contains a hash tag, e.g. "{user1000}.following", only the tag is hashed.
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % hashSlots
+}
+
+// crc16 implements the CRC16-CCITT (XMODEM) checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
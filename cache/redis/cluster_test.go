@@ -0,0 +1,78 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlot(t *testing.T) {
+	for key, slot := range map[string]int{
+		"123456789":            12739,
+		"foo":                  12182,
+		"{user1000}.following": Slot("user1000"),
+		"{user1000}.followers": Slot("user1000"),
+		"foo{}{bar}":           Slot("foo{}{bar}"),
+		"foo{{bar}}zap":        Slot("{bar"),
+	} {
+		assert.Equal(t, slot, Slot(key), key)
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		asking bool
+		slot   int
+		addr   string
+		ok     bool
+	}{
+		{err: redis.Error("MOVED 3999 127.0.0.1:6381"), slot: 3999, addr: "127.0.0.1:6381", ok: true},
+		{err: redis.Error("ASK 3999 127.0.0.1:6381"), asking: true, slot: 3999, addr: "127.0.0.1:6381", ok: true},
+		{err: redis.Error("MOVED 16384 127.0.0.1:6381")},
+		{err: redis.Error("ERR unknown command")},
+		{err: errors.New("MOVED 3999 127.0.0.1:6381")},
+		{},
+	} {
+		asking, slot, addr, ok := parseRedirect(tc.err)
+		assert.Equal(t, tc.ok, ok, tc.err)
+		assert.Equal(t, tc.asking, asking, tc.err)
+		assert.Equal(t, tc.slot, slot, tc.err)
+		assert.Equal(t, tc.addr, addr, tc.err)
+	}
+}
+
+func TestClusterGetMulti(t *testing.T) {
+	m1, m2 := miniredis.RunT(t), miniredis.RunT(t)
+	// The first half of the slots is served by m1 and the second half by m2.
+	slots := make([]string, hashSlots)
+	for i := range slots {
+		slots[i] = m1.Addr()
+		if i >= hashSlots/2 {
+			slots[i] = m2.Addr()
+		}
+	}
+	cluster := &Cluster{slots: slots}
+	defer cluster.Close()
+	c := NewWithCluster(cluster)
+
+	values := map[string]string{"post#1": "1", "post#2": "2", "user#1": "3", "->post": "4"}
+	for k, v := range values {
+		m := m1
+		if Slot(k) >= hashSlots/2 {
+			m = m2
+		}
+		require.NoError(t, m.Set(k, v))
+	}
+	require.NotEmpty(t, m1.Keys())
+	require.NotEmpty(t, m2.Keys())
+
+	got, err := c.GetMulti(context.Background(), "post#1", "post#2", "user#1", "->post", "post#3")
+	require.NoError(t, err)
+	assert.Equal(t, values, got)
+}
@@ -0,0 +1,87 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Pool provides connections to a Redis server.
+// It is implemented by *redis.Pool.
+// Connections are returned to the Pool by closing them.
+type Pool interface {
+	GetContext(context.Context) (redis.Conn, error)
+}
+
+var _ Pool = &redis.Pool{}
+
+// errBrokenConn is returned by a connPool without a dial function once its connection broke.
+var errBrokenConn = errors.New("connection is broken and can not be dialed again")
+
+// connPool is a Pool that hands out a single connection to one caller at a time.
+// A connection that broke is closed and, if the pool has a dial function, dialed again when it is borrowed the next time.
+type connPool struct {
+	mu   chan struct{}
+	conn redis.Conn
+	dial func(context.Context) (redis.Conn, error)
+}
+
+func newConnPool(conn redis.Conn, dial func(context.Context) (redis.Conn, error)) *connPool {
+	return &connPool{
+		mu:   make(chan struct{}, 1),
+		conn: conn,
+		dial: dial,
+	}
+}
+
+func (p *connPool) GetContext(ctx context.Context) (redis.Conn, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case p.mu <- struct{}{}:
+	}
+	unlock := func() { <-p.mu }
+
+	if p.conn != nil && p.conn.Err() != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	if p.conn == nil {
+		if p.dial == nil {
+			unlock()
+			return nil, errBrokenConn
+		}
+		conn, err := p.dial(ctx)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		p.conn = conn
+	}
+
+	return &lockedConn{Conn: p.conn, unlock: unlock}, nil
+}
+
+// lockedConn releases the connection instead of closing it.
+type lockedConn struct {
+	redis.Conn
+
+	once   sync.Once
+	unlock func()
+}
+
+func (c *lockedConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoContext(c.Conn, ctx, cmd, args...)
+}
+
+func (c *lockedConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redis.ReceiveContext(c.Conn, ctx)
+}
+
+func (c *lockedConn) Close() error {
+	c.once.Do(c.unlock)
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package rediscache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnPool(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("dial", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		c := NewWithDial(m.Addr(), nil)
+
+		require.NoError(t, c.SetContext(ctx, "post#1", "1"))
+		// Closing the server breaks the connection.
+		m.Close()
+		require.NoError(t, m.Restart())
+		_, err := c.GetContext(ctx, "post#1")
+		assert.Error(t, err)
+
+		// The broken connection is dropped and dialed again.
+		v, err := c.GetContext(ctx, "post#1")
+		require.NoError(t, err)
+		assert.Equal(t, "1", v)
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		conn, err := redis.Dial("tcp", m.Addr())
+		require.NoError(t, err)
+		c := New(conn)
+
+		require.NoError(t, c.SetContext(ctx, "post#1", "1"))
+		m.Close()
+		require.NoError(t, m.Restart())
+		_, err = c.GetContext(ctx, "post#1")
+		assert.Error(t, err)
+
+		// A connection that can not be dialed again fails instead of blocking.
+		_, err = c.GetContext(ctx, "post#1")
+		assert.ErrorIs(t, err, errBrokenConn)
+	})
+}
+
+// runMaster starts a miniredis server that answers ROLE with the given role.
+func runMaster(t *testing.T, role *string, mu *sync.Mutex) *miniredis.Miniredis {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	require.NoError(t, m.Server().Register("ROLE", func(c *server.Peer, _ string, _ []string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		c.WriteLen(3)
+		c.WriteBulk(*role)
+		c.WriteInt(0)
+		c.WriteLen(0)
+	}))
+
+	return m
+}
+
+func TestSentinel(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	role1, role2 := "master", "slave"
+	m1 := runMaster(t, &role1, &mu)
+	m2 := runMaster(t, &role2, &mu)
+	master := m1
+
+	s := miniredis.RunT(t)
+	require.NoError(t, s.Server().Register("SENTINEL", func(c *server.Peer, _ string, args []string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(args) != 2 || args[0] != "get-master-addr-by-name" || args[1] != "mymaster" {
+			c.WriteNull()
+			return
+		}
+		c.WriteStrings([]string{master.Host(), master.Port()})
+	}))
+
+	// The first sentinel is not reachable.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	sentinel := &Sentinel{Addrs: []string{unreachable, s.Addr()}, MasterName: "mymaster"}
+	addr, err := sentinel.MasterAddr(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, m1.Addr(), addr)
+	// The sentinel that answered is asked first on the next call.
+	assert.Equal(t, []string{s.Addr(), unreachable}, sentinel.Addrs)
+
+	p := NewSentinelPool(sentinel, nil)
+	defer p.Close()
+	c := NewWithPool(p)
+	require.NoError(t, c.SetContext(ctx, "post#1", "1"))
+	assert.True(t, m1.Exists("post#1"))
+
+	// Fail over to the second server.
+	mu.Lock()
+	master, role1, role2 = m2, "slave", "master"
+	mu.Unlock()
+	m1.Close()
+	_, err = c.GetContext(ctx, "post#1")
+	assert.Error(t, err)
+	require.NoError(t, c.SetContext(ctx, "post#1", "2"))
+	assert.True(t, m2.Exists("post#1"))
+
+	// A server that is not the master is rejected.
+	mu.Lock()
+	master = m2
+	role2 = "slave"
+	mu.Unlock()
+	_, err = NewSentinelPool(sentinel, nil).GetContext(ctx)
+	assert.ErrorContains(t, err, `role "slave"`)
+
+	_, err = (&Sentinel{MasterName: "mymaster"}).MasterAddr(ctx)
+	assert.Error(t, err)
+}
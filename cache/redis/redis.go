@@ -1,51 +1,259 @@
 package rediscache
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/hashicorp/go-multierror"
 
 	"github.com/connylabs/zedcache/cache"
 )
 
-var _ cache.Cache = &Cache{}
+var (
+	_ cache.BatchCache     = &Cache{}
+	_ cache.Cache          = &Cache{}
+	_ cache.ContextCache   = &Cache{}
+	_ cache.MonotonicCache = &Cache{}
+	_ cache.TTLCache       = &Cache{}
+)
+
+// compareAndSet sets KEYS[1] to ARGV[2] if its current value is ARGV[1].
+// If ARGV[3] is "1", KEYS[1] is only set if it does not exist.
+// If ARGV[4] is greater than 0, KEYS[1] expires after ARGV[4] milliseconds.
+// It returns 1 if the value was set and 0 otherwise.
+var compareAndSet = redis.NewScript(1, `
+local v = redis.call("GET", KEYS[1])
+if (ARGV[3] == "1" and v == false) or (ARGV[3] ~= "1" and v == ARGV[1]) then
+	if tonumber(ARGV[4]) > 0 then
+		redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[4])
+	else
+		redis.call("SET", KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0
+`)
 
+// New creates a Cache that uses a single connection.
+// The connection is only used by one operation at a time.
+// If the connection breaks, all further operations fail; use NewWithDial to dial it again.
+// Prefer NewWithPool for caches that are used by many goroutines.
 func New(c redis.Conn) *Cache {
-	return &Cache{c}
+	return &Cache{p: newConnPool(c, nil)}
+}
+
+// NewWithDial creates a Cache that uses a single connection to the Redis server with the given address, like New.
+// The connection is dialed on first use and dialed again after it broke.
+// If dial is nil, a plain TCP connection is used.
+func NewWithDial(addr string, dial DialFunc) *Cache {
+	if dial == nil {
+		dial = defaultDial
+	}
+
+	return &Cache{p: newConnPool(nil, func(ctx context.Context) (redis.Conn, error) {
+		return dial(ctx, addr)
+	})}
+}
+
+// NewWithPool creates a Cache that borrows a connection from the given pool for every operation.
+// Use a *redis.Pool or the pool returned by NewSentinelPool.
+func NewWithPool(p Pool) *Cache {
+	return &Cache{p: p}
+}
+
+// NewWithCluster creates a Cache that routes every operation to the node of the given Redis Cluster that serves the key.
+func NewWithCluster(c *Cluster) *Cache {
+	return &Cache{cluster: c}
 }
 
 type Cache struct {
-	conn redis.Conn
+	p       Pool
+	cluster *Cluster
+}
+
+// conn returns a connection that can be used to run commands for the given key.
+func (c *Cache) conn(ctx context.Context, key string) (redis.Conn, error) {
+	if c.cluster != nil {
+		return c.cluster.GetContext(ctx, key)
+	}
+
+	return c.p.GetContext(ctx)
 }
 
 func (c *Cache) Get(key string) (string, error) {
-	val, err := redis.String(c.conn.Do("GET", key))
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *Cache) Set(key, value string) error {
+	return c.SetContext(context.Background(), key, value)
+}
+
+func (c *Cache) Del(keys ...string) error {
+	return c.DelContext(context.Background(), keys...)
+}
+
+func (c *Cache) GetContext(ctx context.Context, key string) (string, error) {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	val, err := redis.String(redis.DoContext(conn, ctx, "GET", key))
 	if err != nil {
-		fmt.Println(err.Error())
 		if errors.Is(err, redis.ErrNil) {
 			return "", cache.ErrCacheMiss
 		}
 
 		return "", fmt.Errorf("failed to get cached entry for key %q: %w", key, err)
 	}
-	fmt.Printf("value: %v\n", val)
 
 	return val, nil
 }
 
-func (c *Cache) Set(key, value string) error {
-	_, err := c.conn.Do("SET", key, value)
+func (c *Cache) SetContext(ctx context.Context, key, value string) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+func (c *Cache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	args := []interface{}{key, value}
+	if ttl > 0 {
+		args = append(args, "PX", milliseconds(ttl))
+	}
+	_, err = redis.DoContext(conn, ctx, "SET", args...)
 
 	return err
 }
 
-func (c *Cache) Del(keys ...string) error {
+// milliseconds rounds the given ttl up to full milliseconds, the resolution of Redis' PX.
+func milliseconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+func (c *Cache) DelContext(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if c.cluster != nil {
+		return c.delCluster(ctx, keys)
+	}
+
+	conn, err := c.p.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
 	eIs := make([]any, len(keys))
 	for i := range keys {
 		eIs[i] = keys[i]
 	}
-	_, err := c.conn.Do("DEL", eIs...)
+	_, err = redis.DoContext(conn, ctx, "DEL", eIs...)
 
 	return err
 }
+
+// nodes groups the keys by the address of the cluster node that serves them.
+func (c *Cache) nodes(ctx context.Context, keys []string) (map[string][]string, error) {
+	nodes := make(map[string][]string)
+	for _, k := range keys {
+		addr, err := c.cluster.addr(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		nodes[addr] = append(nodes[addr], k)
+	}
+
+	return nodes, nil
+}
+
+// delCluster groups the keys by the node that serves them and pipelines one DEL per key to every node.
+// Keys whose deletion was redirected by the cluster are deleted one by one.
+func (c *Cache) delCluster(ctx context.Context, keys []string) error {
+	nodes, err := c.nodes(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	g := multierror.Group{}
+	for _, ks := range nodes {
+		ks := ks
+		g.Go(func() error {
+			conn, err := c.cluster.GetContext(ctx, ks[0])
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			for _, k := range ks {
+				if err := conn.Send("DEL", k); err != nil {
+					return err
+				}
+			}
+			if err := conn.Flush(); err != nil {
+				return err
+			}
+			var redirected []string
+			for _, k := range ks {
+				_, err := redis.ReceiveContext(conn, ctx)
+				if _, _, _, ok := parseRedirect(err); ok {
+					redirected = append(redirected, k)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+			for _, k := range redirected {
+				if _, err := redis.DoContext(conn, ctx, "DEL", k); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait().ErrorOrNil()
+}
+
+// SetIfNewer compares the cached value with value and replaces it with a Lua script,
+// if it did not change in the meantime. Otherwise the comparison is retried.
+func (c *Cache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	for {
+		old, err := c.GetContext(ctx, key)
+		missing := errors.Is(err, cache.ErrCacheMiss)
+		if err != nil && !missing {
+			return err
+		}
+		if !missing && !cache.IsNewer(value, old, cmp) {
+			return nil
+		}
+
+		conn, err := c.conn(ctx, key)
+		if err != nil {
+			return err
+		}
+		flag := "0"
+		if missing {
+			flag = "1"
+		}
+		set, err := redis.Bool(compareAndSet.DoContext(ctx, conn, key, old, value, flag, milliseconds(ttl)))
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("failed to set cached entry for key %q: %w", key, err)
+		}
+		if set {
+			return nil
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package rediscache
 
 import (
+	"context"
 	"os"
 	"testing"
-	"time"
 
 	"github.com/efficientgo/e2e"
 	"github.com/gomodule/redigo/redis"
@@ -11,16 +11,20 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/cachetest"
 )
 
-func TestCache(t *testing.T) {
+// startRedis starts a Redis server in Docker, if the E2E environment variable is set.
+func startRedis(tb testing.TB) e2e.Runnable {
+	tb.Helper()
+
 	if v, ok := os.LookupEnv("E2E"); !ok || !(v == "1" || v == "true") {
-		t.Skip("To enable this test, set the E2E environment variable to 1 or true")
+		tb.Skip("To enable this test, set the E2E environment variable to 1 or true")
 	}
 
 	e, err := e2e.NewDockerEnvironment("redis-cache-e2e")
-	require.NoError(t, err)
-	require.NoError(t, err)
+	require.NoError(tb, err)
+	tb.Cleanup(e.Close)
 	r := e.Runnable("redis").WithPorts(
 		map[string]int{
 			"redis": 6379,
@@ -28,67 +32,92 @@ func TestCache(t *testing.T) {
 		Image:     "redis:7.0-alpine",
 		Readiness: e2e.NewTCPReadinessProbe("redis"),
 	})
-	require.NoError(t, r.Start())
-	require.NoError(t, r.WaitReady())
-
-	t.Run("miss", func(t *testing.T) {
-		c := New(connection(t, r))
-		_, err := c.Get("key")
-		assert.ErrorIs(t, err, cache.ErrCacheMiss)
-	})
-
-	t.Run("hit", func(t *testing.T) {
-		c := New(connection(t, r))
-
-		assert.NoError(t, c.Set("key", "value"))
-		time.Sleep(time.Second)
+	require.NoError(tb, r.Start())
+	require.NoError(tb, r.WaitReady())
 
-		v, err := c.Get("key")
+	return r
+}
 
-		assert.NoError(t, err)
+func TestCache(t *testing.T) {
+	r := startRedis(t)
 
-		assert.Equal(t, "value", v)
+	t.Run("connection", func(t *testing.T) {
+		cachetest.Run(t, func(t *testing.T) cache.Cache {
+			return New(connection(t, r))
+		})
 	})
 
-	t.Run("del", func(t *testing.T) {
-		c := New(connection(t, r))
-
-		assert.NoError(t, c.Set("key", "value"))
-		time.Sleep(time.Second)
-
-		_, err := c.Get("key")
-
-		assert.NoError(t, err)
-
-		assert.NoError(t, c.Del("key"))
-
-		_, err = c.Get("key")
-		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+	t.Run("pool", func(t *testing.T) {
+		cachetest.Run(t, func(t *testing.T) cache.Cache {
+			return pool(t, r)
+		})
 	})
 
-	t.Run("hit after overwrite", func(t *testing.T) {
-		c := New(connection(t, r))
-
-		assert.NoError(t, c.Set("key", "value"))
-		assert.NoError(t, c.Set("key", "value!"))
-		time.Sleep(time.Second)
-
-		v, err := c.Get("key")
+	t.Run("bus", func(t *testing.T) {
+		p := &redis.Pool{
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", r.Endpoint("redis"))
+			},
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, p.Close())
+		})
+		b := NewBus(p, "")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ready := make(chan struct{})
+		received := make(chan []string, 1)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- b.Subscribe(ctx, func() { close(ready) }, func(keys ...string) {
+				received <- keys
+			})
+		}()
+		<-ready
+
+		require.NoError(t, b.Publish(ctx, "a", "b"))
+		assert.Equal(t, []string{"a", "b"}, <-received)
+		cancel()
+		assert.Error(t, <-errCh)
+	})
+}
 
-		assert.NoError(t, err)
+func BenchmarkCache(b *testing.B) {
+	r := startRedis(b)
 
-		assert.Equal(t, "value!", v)
+	cachetest.Benchmark(b, func(b *testing.B) cache.Cache {
+		return pool(b, r)
 	})
 }
 
-func connection(t *testing.T, r e2e.Runnable) redis.Conn {
-	t.Helper()
+func connection(tb testing.TB, r e2e.Runnable) redis.Conn {
+	tb.Helper()
 
 	conn, err := redis.Dial("tcp", r.Endpoint("redis"))
-	require.NoError(t, err)
-	t.Cleanup(func() {
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
 		_, err := conn.Do("FLUSHALL", "SYNC")
-		require.NoError(t, err)
+		require.NoError(tb, err)
 	})
 	return conn
 }
+
+// pool returns a Cache that borrows connections from a pool.
+func pool(tb testing.TB, r e2e.Runnable) *Cache {
+	tb.Helper()
+
+	p := &redis.Pool{
+		MaxIdle: 4,
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", r.Endpoint("redis"))
+		},
+	}
+	tb.Cleanup(func() {
+		assert.NoError(tb, p.Close())
+	})
+	// Flush the database after the test.
+	connection(tb, r)
+
+	return NewWithPool(p)
+}
@@ -0,0 +1,139 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/hashicorp/go-multierror"
+)
+
+// DialFunc creates a new connection to the Redis server with the given address.
+type DialFunc func(ctx context.Context, addr string) (redis.Conn, error)
+
+func defaultDial(ctx context.Context, addr string) (redis.Conn, error) {
+	return redis.DialContext(ctx, "tcp", addr)
+}
+
+// Sentinel discovers the current master of a set of Redis servers monitored by Redis Sentinel.
+type Sentinel struct {
+	// Addrs are the addresses of the sentinels.
+	Addrs []string
+	// MasterName is the name of the monitored master.
+	MasterName string
+	// Dial is used to connect to the sentinels.
+	// If nil, a plain TCP connection is used.
+	Dial DialFunc
+
+	mu sync.Mutex
+}
+
+// MasterAddr asks the sentinels for the address of the current master.
+// The first sentinel that answers is asked first on the next call.
+func (s *Sentinel) MasterAddr(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dial := s.Dial
+	if dial == nil {
+		dial = defaultDial
+	}
+
+	var errs error
+	for i, addr := range s.Addrs {
+		master, err := s.masterAddr(ctx, dial, addr)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("sentinel %q: %w", addr, err))
+			continue
+		}
+		// Move the sentinel to the front, so that it is asked first the next time.
+		copy(s.Addrs[1:i+1], s.Addrs[:i])
+		s.Addrs[0] = addr
+
+		return master, nil
+	}
+	if errs == nil {
+		errs = errors.New("no sentinel addresses configured")
+	}
+
+	return "", fmt.Errorf("failed to discover master %q: %w", s.MasterName, errs)
+}
+
+func (s *Sentinel) masterAddr(ctx context.Context, dial DialFunc, addr string) (string, error) {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	res, err := redis.Strings(redis.DoContext(conn, ctx, "SENTINEL", "get-master-addr-by-name", s.MasterName))
+	if err != nil {
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", fmt.Errorf("unexpected reply %v", res)
+	}
+
+	return net.JoinHostPort(res[0], res[1]), nil
+}
+
+// NewSentinelPool creates a connection pool to the master that is discovered with the given Sentinel.
+// After a failover, connections to the former master are discarded when they are borrowed from the pool.
+// The dial function is used to connect to the master. If it is nil, a plain TCP connection is used.
+func NewSentinelPool(s *Sentinel, dial DialFunc) *redis.Pool {
+	if dial == nil {
+		dial = defaultDial
+	}
+
+	return &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			addr, err := s.MasterAddr(ctx)
+			if err != nil {
+				return nil, err
+			}
+			conn, err := dial(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkRole(conn); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("%q: %w", addr, err)
+			}
+
+			return conn, nil
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Second {
+				return nil
+			}
+
+			return checkRole(conn)
+		},
+	}
+}
+
+// checkRole returns an error if the connected server is not a master.
+func checkRole(conn redis.Conn) error {
+	res, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return errors.New("unexpected empty reply to ROLE")
+	}
+	role, err := redis.String(res[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != "master" {
+		return fmt.Errorf("server has role %q instead of master", role)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package sharded implements an in-memory cache.Cache that spreads its entries over independently locked shards.
+package sharded
+
+import (
+	"context"
+	"hash/maphash"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// DefaultSweepInterval is the default interval in which expired entries are removed from a shard.
+const DefaultSweepInterval = time.Minute
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// WithShards sets the number of shards. It is rounded up to the next power of two.
+// By default the Cache has four shards per CPU.
+func WithShards(n int) Option {
+	return func(c *Cache) {
+		c.n = n
+	}
+}
+
+// WithSweepInterval sets the interval in which expired entries are removed from a shard.
+// Shards are only swept when they are written to, so the Cache does not run any goroutines.
+// Expired entries are never returned, regardless of the interval.
+func WithSweepInterval(d time.Duration) Option {
+	return func(c *Cache) {
+		c.sweepInterval = d
+	}
+}
+
+var (
+	_ cache.Cache          = &Cache{}
+	_ cache.ContextCache   = &Cache{}
+	_ cache.MonotonicCache = &Cache{}
+	_ cache.TTLCache       = &Cache{}
+)
+
+type item struct {
+	value string
+	// expires is the time in Unix nanoseconds after which the item expires, or 0 if it does not expire.
+	expires int64
+}
+
+func (i item) expired(now int64) bool {
+	return i.expires != 0 && now >= i.expires
+}
+
+// expiredNow is like expired, but only reads the clock for items that expire.
+func (i item) expiredNow() bool {
+	return i.expires != 0 && time.Now().UnixNano() >= i.expires
+}
+
+type shard struct {
+	mu        sync.RWMutex
+	items     map[string]item
+	nextSweep int64
+	// Pad the shards to separate cache lines, so that the locks of adjacent shards do not contend.
+	_ [64]byte
+}
+
+// Cache is an in-memory cache whose keys are distributed over shards with separate locks,
+// so that concurrent operations on different keys rarely contend.
+type Cache struct {
+	n             int
+	sweepInterval time.Duration
+	seed          maphash.Seed
+	mask          uint64
+	shards        []shard
+}
+
+// New creates a Cache.
+func New(opts ...Option) *Cache {
+	c := &Cache{
+		n:             4 * runtime.GOMAXPROCS(0),
+		sweepInterval: DefaultSweepInterval,
+		seed:          maphash.MakeSeed(),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	n := 1
+	for n < c.n {
+		n <<= 1
+	}
+	c.mask = uint64(n - 1)
+	c.shards = make([]shard, n)
+	for i := range c.shards {
+		c.shards[i].items = make(map[string]item)
+	}
+
+	return c
+}
+
+func (c *Cache) index(key string) uint64 {
+	return maphash.String(c.seed, key) & c.mask
+}
+
+func (c *Cache) shard(key string) *shard {
+	return &c.shards[c.index(key)]
+}
+
+func (c *Cache) Get(key string) (string, error) {
+	s := c.shard(key)
+	s.mu.RLock()
+	i, ok := s.items[key]
+	s.mu.RUnlock()
+	if !ok || i.expiredNow() {
+		return "", cache.ErrCacheMiss
+	}
+
+	return i.value, nil
+}
+
+func (c *Cache) Set(key, value string) error {
+	c.set(key, value, 0)
+
+	return nil
+}
+
+func (c *Cache) set(key, value string, ttl time.Duration) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	c.sweep(s, now)
+	s.items[key] = newItem(value, ttl, now)
+}
+
+func newItem(value string, ttl time.Duration, now int64) item {
+	i := item{value: value}
+	if ttl > 0 {
+		i.expires = now + int64(ttl)
+	}
+
+	return i
+}
+
+// sweep removes the expired items of the locked shard, if the sweep interval passed.
+func (c *Cache) sweep(s *shard, now int64) {
+	if now < s.nextSweep {
+		return
+	}
+	for k, i := range s.items {
+		if i.expired(now) {
+			delete(s.items, k)
+		}
+	}
+	s.nextSweep = now + int64(c.sweepInterval)
+}
+
+// Del deletes the keys. Each shard is locked once for all of its keys.
+func (c *Cache) Del(keys ...string) error {
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		s := c.shard(keys[0])
+		s.mu.Lock()
+		delete(s.items, keys[0])
+		s.mu.Unlock()
+		return nil
+	}
+
+	idx := make([]uint64, len(keys))
+	for i, k := range keys {
+		idx[i] = c.index(k)
+	}
+	// Lock every shard once and delete all of its keys.
+	// The index of a key is set to a value that is out of range once the key was deleted.
+	done := c.mask + 1
+	for i := range keys {
+		if idx[i] == done {
+			continue
+		}
+		n := idx[i]
+		s := &c.shards[n]
+		s.mu.Lock()
+		for j := i; j < len(keys); j++ {
+			if idx[j] == n {
+				delete(s.items, keys[j])
+				idx[j] = done
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (c *Cache) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return c.Get(key)
+}
+
+func (c *Cache) SetContext(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Set(key, value)
+}
+
+func (c *Cache) DelContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Del(keys...)
+}
+
+func (c *Cache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.set(key, value, ttl)
+
+	return nil
+}
+
+func (c *Cache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	if old, ok := s.items[key]; ok && !old.expired(now) && !cache.IsNewer(value, old.value, cmp) {
+		return nil
+	}
+	c.sweep(s, now)
+	s.items[key] = newItem(value, ttl, now)
+
+	return nil
+}
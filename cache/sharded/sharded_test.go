@@ -0,0 +1,57 @@
+package sharded
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/cachetest"
+)
+
+func TestCache(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return New()
+	})
+}
+
+func TestShards(t *testing.T) {
+	assert.Len(t, New(WithShards(5)).shards, 8)
+	assert.Len(t, New(WithShards(1)).shards, 1)
+
+	t.Run("del batch", func(t *testing.T) {
+		c := New(WithShards(4))
+		keys := make([]string, 100)
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+			require.NoError(t, c.Set(keys[i], keys[i]))
+		}
+		require.NoError(t, c.Del(keys[:50]...))
+		for i, k := range keys {
+			_, err := c.Get(k)
+			if i < 50 {
+				assert.ErrorIs(t, err, cache.ErrCacheMiss)
+			} else {
+				assert.NoError(t, err)
+			}
+		}
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		c := New(WithShards(1), WithSweepInterval(10*time.Millisecond))
+		require.NoError(t, c.SetWithTTL(context.Background(), "a", "1", time.Millisecond))
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, c.Set("b", "2"))
+		assert.Len(t, c.shards[0].items, 1)
+	})
+}
+
+func BenchmarkCache(b *testing.B) {
+	cachetest.Benchmark(b, func(b *testing.B) cache.Cache {
+		return New()
+	})
+}
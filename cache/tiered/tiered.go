@@ -0,0 +1,195 @@
+// Package tiered implements a cache.Cache that reads through a fast local cache in front of a shared remote cache.
+package tiered
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// DefaultL1TTL is the default time after which entries of the local cache expire.
+const DefaultL1TTL = time.Second
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// WithL1TTL sets the time after which entries of the local cache expire.
+// Other instances that share the remote cache can not delete the entries of the local cache,
+// so the ttl bounds how long a local cache can return a zedtoken that another instance already invalidated.
+// Entries that are read from L2 can outlive their own ttl by up to the ttl of L1,
+// so it should be much shorter than the ttl of the cached zedtokens.
+// The local cache must implement cache.TTLCache for the ttl to take effect.
+func WithL1TTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.l1TTL = ttl
+	}
+}
+
+// Cache reads through a local cache (L1), e.g. go-cache, in front of a shared remote cache (L2), e.g. Redis.
+// Values are written to L2 and invalidated in L1, so L1 only holds values that were read from L2.
+// Deleted keys are deleted from both.
+type Cache struct {
+	l1    cache.ContextCache
+	l2    cache.ContextCache
+	l1TTL time.Duration
+
+	// mu guards epoch. The epoch is incremented whenever L1 is invalidated,
+	// so that values that were read from L2 before the invalidation are not written to L1 afterwards.
+	mu    sync.Mutex
+	epoch uint64
+}
+
+var (
+	_ cache.BatchCache     = &Cache{}
+	_ cache.Cache          = &Cache{}
+	_ cache.ContextCache   = &Cache{}
+	_ cache.MonotonicCache = &Cache{}
+	_ cache.TTLCache       = &Cache{}
+)
+
+// New creates a Cache with the local cache l1 in front of the remote cache l2.
+func New(l1, l2 cache.Cache, opts ...Option) *Cache {
+	c := &Cache{
+		l1:    cache.WithContext(l1),
+		l2:    cache.WithContext(l2),
+		l1TTL: DefaultL1TTL,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+func (c *Cache) Get(key string) (string, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *Cache) Set(key, value string) error {
+	return c.SetContext(context.Background(), key, value)
+}
+
+func (c *Cache) Del(keys ...string) error {
+	return c.DelContext(context.Background(), keys...)
+}
+
+// GetContext returns the value from L1 or, if L1 misses, reads it from L2 and writes it to L1.
+func (c *Cache) GetContext(ctx context.Context, key string) (string, error) {
+	if v, err := c.l1.GetContext(ctx, key); err == nil {
+		return v, nil
+	}
+
+	c.mu.Lock()
+	epoch := c.epoch
+	c.mu.Unlock()
+
+	v, err := c.l2.GetContext(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Only fill L1 if it was not invalidated while the value was read from L2.
+	// Errors of L1 are ignored, because the value can still be read from L2.
+	if epoch == c.epoch {
+		cache.SetWithTTL(ctx, c.l1, key, v, c.l1TTL)
+	}
+
+	return v, nil
+}
+
+func (c *Cache) SetContext(ctx context.Context, key, value string) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+func (c *Cache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := cache.SetWithTTL(ctx, c.l2, key, value, ttl); err != nil {
+		return err
+	}
+
+	return c.invalidate(ctx, key)
+}
+
+// SetIfNewer sets the value in L2 and removes the key from L1,
+// because L2 might have kept a newer value.
+func (c *Cache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	if err := cache.SetIfNewer(ctx, c.l2, key, value, ttl, cmp); err != nil {
+		return err
+	}
+
+	return c.invalidate(ctx, key)
+}
+
+// GetMulti returns the values from L1 and reads the keys that L1 misses from L2 in a single batch.
+func (c *Cache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	values, err := cache.GetMulti(ctx, c.l1, keys...)
+	if err != nil {
+		values = make(map[string]string, len(keys))
+	}
+	missing := make([]string, 0, len(keys)-len(values))
+	for _, k := range keys {
+		if _, ok := values[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	c.mu.Lock()
+	epoch := c.epoch
+	c.mu.Unlock()
+
+	l2, err := cache.GetMulti(ctx, c.l2, missing...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Only fill L1 if it was not invalidated while the values were read from L2.
+	if epoch == c.epoch && len(l2) > 0 {
+		cache.SetMulti(ctx, c.l1, l2, c.l1TTL, nil)
+	}
+	for k, v := range l2 {
+		values[k] = v
+	}
+
+	return values, nil
+}
+
+// SetMulti sets the values in L2 in a single batch and removes the keys from L1.
+func (c *Cache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	if err := cache.SetMulti(ctx, c.l2, values, ttl, cmp); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+
+	return c.invalidate(ctx, keys...)
+}
+
+// DelContext deletes the keys from L2 and then from L1,
+// so that L1 can not be filled with the deleted values afterwards.
+func (c *Cache) DelContext(ctx context.Context, keys ...string) error {
+	if err := c.l2.DelContext(ctx, keys...); err != nil {
+		return err
+	}
+
+	return c.invalidate(ctx, keys...)
+}
+
+// invalidate deletes the keys from L1.
+func (c *Cache) invalidate(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+
+	return c.l1.DelContext(ctx, keys...)
+}
@@ -0,0 +1,124 @@
+package tiered
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/cachetest"
+	"github.com/connylabs/zedcache/cache/go-cache"
+)
+
+func newGocache() *gocache.Cache {
+	return gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))
+}
+
+// gatedCache calls hook after every lookup.
+type gatedCache struct {
+	*gocache.Cache
+
+	hook func()
+}
+
+func (c *gatedCache) GetContext(ctx context.Context, key string) (string, error) {
+	defer c.hook()
+
+	return c.Cache.GetContext(ctx, key)
+}
+
+func TestCache(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		// The suite's ttl resolution must exceed the ttl of L1.
+		return New(newGocache(), newGocache(), WithL1TTL(10*time.Millisecond))
+	})
+}
+
+func TestTiers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("read through", func(t *testing.T) {
+		l1, l2 := newGocache(), newGocache()
+		c := New(l1, l2)
+		require.NoError(t, l2.Set("a", "1"))
+
+		v, err := c.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, "1", v)
+		v, err = l1.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, "1", v)
+	})
+
+	t.Run("l1 ttl", func(t *testing.T) {
+		l1, l2 := newGocache(), newGocache()
+		c := New(l1, l2, WithL1TTL(50*time.Millisecond))
+		require.NoError(t, l2.Set("a", "1"))
+
+		_, err := c.Get("a")
+		require.NoError(t, err)
+		time.Sleep(150 * time.Millisecond)
+		_, err = l1.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+	})
+
+	t.Run("write invalidates l1", func(t *testing.T) {
+		l1, l2 := newGocache(), newGocache()
+		c := New(l1, l2)
+		require.NoError(t, c.Set("a", "1"))
+		_, err := c.Get("a")
+		require.NoError(t, err)
+
+		require.NoError(t, c.SetIfNewer(ctx, "a", "2", 0, func(a, b string) (int, error) {
+			return strings.Compare(a, b), nil
+		}))
+		_, err = l1.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+		v, err := c.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, "2", v)
+	})
+
+	t.Run("del", func(t *testing.T) {
+		l1, l2 := newGocache(), newGocache()
+		c := New(l1, l2)
+		require.NoError(t, c.Set("a", "1"))
+		_, err := c.Get("a")
+		require.NoError(t, err)
+
+		require.NoError(t, c.Del("a"))
+		_, err = l1.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+		_, err = l2.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+	})
+
+	t.Run("del during read through", func(t *testing.T) {
+		l1 := newGocache()
+		l2 := &gatedCache{Cache: newGocache()}
+		c := New(l1, l2)
+		require.NoError(t, c.Set("a", "1"))
+
+		// Delete the key after the value was read from L2 but before it is written to L1.
+		l2.hook = func() {
+			l2.hook = func() {}
+			go func() {
+				assert.NoError(t, c.Del("a"))
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+		v, err := c.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, "1", v)
+
+		_, err = l1.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+		_, err = c.Get("a")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+	})
+}
@@ -0,0 +1,261 @@
+// zedcache-proxy serves SpiceDB's PermissionsService in front of an upstream SpiceDB and caches zedtokens with zedcache.
+// The SchemaService and WatchService are passed through to the upstream.
+// Clients that can not use the Go package get the same caching by pointing their SpiceDB endpoint at the proxy.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/authzed/authzed-go/v1"
+	gmc "github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gomodule/redigo/redis"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/connylabs/zedcache"
+	"github.com/connylabs/zedcache/cache"
+	gocache "github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/cache/lru"
+	"github.com/connylabs/zedcache/cache/memcached"
+	noopcache "github.com/connylabs/zedcache/cache/noop"
+	rediscache "github.com/connylabs/zedcache/cache/redis"
+	"github.com/connylabs/zedcache/internal/proxy"
+)
+
+const (
+	backendMemory    = "memory"
+	backendRedis     = "redis"
+	backendMemcached = "memcached"
+	backendNone      = "none"
+)
+
+type flags struct {
+	listen           string
+	metricsListen    string
+	upstream         string
+	upstreamInsecure bool
+	upstreamToken    string
+	backend          string
+	memoryMaxEntries int
+	redisAddrs       string
+	redisMode        string
+	redisMasterName  string
+	memcachedAddrs   string
+	gcWindow         time.Duration
+	invalidation     string
+	namespace        string
+	logLevel         string
+}
+
+func main() {
+	f := &flags{}
+	fs := flag.NewFlagSet("zedcache-proxy", flag.ExitOnError)
+	fs.StringVar(&f.listen, "listen", ":50051", "The address on which the gRPC API is served.")
+	fs.StringVar(&f.metricsListen, "metrics-listen", ":9090", "The address on which metrics are served. Leave empty to disable.")
+	fs.StringVar(&f.upstream, "upstream", "", "The address of the upstream SpiceDB.")
+	fs.BoolVar(&f.upstreamInsecure, "upstream-insecure", false, "Connect to the upstream SpiceDB without TLS.")
+	fs.StringVar(&f.upstreamToken, "upstream-token", "", "The preshared key that is sent to the upstream SpiceDB if a request does not carry its own authorization header.")
+	fs.StringVar(&f.backend, "cache", backendMemory, fmt.Sprintf("The cache backend. One of %q, %q, %q or %q.", backendMemory, backendRedis, backendMemcached, backendNone))
+	fs.IntVar(&f.memoryMaxEntries, "memory-max-entries", 0, "The maximum number of entries of the memory cache. The least recently used entries are evicted. Leave at 0 for an unbounded cache.")
+	fs.StringVar(&f.redisAddrs, "redis-addr", "localhost:6379", "Comma separated addresses of the Redis server, the sentinels or the cluster nodes.")
+	fs.StringVar(&f.redisMode, "redis-mode", "standalone", `How to connect to Redis. One of "standalone", "sentinel" or "cluster".`)
+	fs.StringVar(&f.redisMasterName, "redis-master-name", "mymaster", "The name of the master monitored by the sentinels.")
+	fs.StringVar(&f.memcachedAddrs, "memcached-addr", "localhost:11211", "Comma separated addresses of the memcached servers.")
+	fs.DurationVar(&f.gcWindow, "gc-window", zedcache.DefaultGCWindow, "The GC window of the upstream SpiceDB, i.e. its --datastore-gc-window.")
+	fs.StringVar(&f.invalidation, "schema-invalidation", "", fmt.Sprintf("How to invalidate object types whose permissions depend on written relationships of other objects according to the upstream's schema. One of %q or %q. Leave empty to disable.", zedcache.TokenBump, zedcache.GenerationBump))
+	fs.StringVar(&f.namespace, "namespace", "", "The namespace that prefixes all cache keys, e.g. to share the cache between several SpiceDB instances or environments. Leave empty to not prefix the keys.")
+	fs.StringVar(&f.logLevel, "log-level", "info", `The log level. One of "debug", "info", "warn" or "error".`)
+	fs.Parse(os.Args[1:])
+
+	l := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	l = log.With(l, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller)
+
+	if err := run(f, l); err != nil {
+		level.Error(l).Log("msg", "zedcache-proxy failed", "err", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(f *flags, l log.Logger) error {
+	lf, err := levelFilter(f.logLevel)
+	if err != nil {
+		return err
+	}
+	l = level.NewFilter(l, lf)
+
+	if f.upstream == "" {
+		return errors.New("--upstream is required")
+	}
+	creds := credentials.NewTLS(&tls.Config{})
+	if f.upstreamInsecure {
+		creds = insecure.NewCredentials()
+	}
+	upstream, err := authzed.NewClient(f.upstream, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to create upstream client: %w", err)
+	}
+
+	ca, closeCache, err := newCache(f)
+	if err != nil {
+		return err
+	}
+	defer closeCache()
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	opts := []zedcache.Option{
+		zedcache.WithLogger(log.With(l, "component", "zedcache")),
+		zedcache.WithGCWindow(f.gcWindow),
+		zedcache.WithRegisterer(r),
+		zedcache.WithBackendName(f.backend),
+	}
+	if f.namespace != "" {
+		opts = append(opts, zedcache.WithNamespace(f.namespace))
+	}
+	switch f.invalidation {
+	case "":
+	case zedcache.TokenBump.String():
+		opts = append(opts, zedcache.WithSchema(upstream.SchemaServiceClient, zedcache.TokenBump))
+	case zedcache.GenerationBump.String():
+		opts = append(opts, zedcache.WithSchema(upstream.SchemaServiceClient, zedcache.GenerationBump))
+	default:
+		return fmt.Errorf("unknown schema invalidation %q", f.invalidation)
+	}
+	c := zedcache.New(upstream, ca, opts...)
+
+	s := grpc.NewServer()
+	proxy.Register(s, c, f.upstreamToken)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+
+	lis, err := net.Listen("tcp", f.listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", f.listen, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 2)
+	go func() {
+		level.Info(l).Log("msg", "serving gRPC", "addr", f.listen, "upstream", f.upstream, "cache", f.backend)
+		errCh <- s.Serve(lis)
+	}()
+
+	var ms *http.Server
+	if f.metricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
+		ms = &http.Server{Addr: f.metricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			level.Info(l).Log("msg", "serving metrics", "addr", f.metricsListen)
+			if err := ms.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("failed to serve metrics: %w", err)
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		level.Info(l).Log("msg", "shutting down")
+	case err = <-errCh:
+	}
+	s.GracefulStop()
+	if ms != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ms.Shutdown(shutdownCtx)
+	}
+
+	return err
+}
+
+func levelFilter(l string) (level.Option, error) {
+	switch l {
+	case "debug":
+		return level.AllowDebug(), nil
+	case "info":
+		return level.AllowInfo(), nil
+	case "warn":
+		return level.AllowWarn(), nil
+	case "error":
+		return level.AllowError(), nil
+	default:
+		return nil, fmt.Errorf("unknown log level %q", l)
+	}
+}
+
+// newCache creates the cache backend selected by the flags.
+// The returned function releases the connections of the backend.
+func newCache(f *flags) (cache.Cache, func(), error) {
+	switch f.backend {
+	case backendMemory:
+		if f.memoryMaxEntries > 0 {
+			return lru.New(lru.WithMaxEntries(f.memoryMaxEntries)), func() {}, nil
+		}
+		return gocache.New(gcache.New(gcache.NoExpiration, 10*time.Minute)), func() {}, nil
+	case backendMemcached:
+		return &memcached.MemCache{Client: gmc.New(splitAddrs(f.memcachedAddrs)...)}, func() {}, nil
+	case backendNone:
+		return &noopcache.Noopcache{}, func() {}, nil
+	case backendRedis:
+		addrs := splitAddrs(f.redisAddrs)
+		switch f.redisMode {
+		case "standalone":
+			if len(addrs) != 1 {
+				return nil, nil, errors.New("standalone Redis requires exactly one address")
+			}
+			p := &redis.Pool{
+				MaxIdle:     10,
+				IdleTimeout: 5 * time.Minute,
+				DialContext: func(ctx context.Context) (redis.Conn, error) {
+					return redis.DialContext(ctx, "tcp", addrs[0])
+				},
+			}
+			return rediscache.NewWithPool(p), func() { p.Close() }, nil
+		case "sentinel":
+			p := rediscache.NewSentinelPool(&rediscache.Sentinel{Addrs: addrs, MasterName: f.redisMasterName}, nil)
+			return rediscache.NewWithPool(p), func() { p.Close() }, nil
+		case "cluster":
+			c := &rediscache.Cluster{Addrs: addrs}
+			return rediscache.NewWithCluster(c), func() { c.Close() }, nil
+		default:
+			return nil, nil, fmt.Errorf("unknown Redis mode %q", f.redisMode)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown cache backend %q", f.backend)
+	}
+}
+
+func splitAddrs(s string) []string {
+	var addrs []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+
+	return addrs
+}
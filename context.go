@@ -0,0 +1,81 @@
+package zedcache
+
+import (
+	"context"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type overrideKey struct{}
+
+// override changes how the requests of a single call use the cache.
+type override struct {
+	// consistency is used for requests that do not specify a consistency instead of a cached zedtoken.
+	consistency *pb.Consistency
+	// bypass disables cache lookups and writes.
+	bypass bool
+}
+
+func overrideFromContext(ctx context.Context) override {
+	o, _ := ctx.Value(overrideKey{}).(override)
+	return o
+}
+
+// WithFullConsistency returns a copy of the context with which requests that do not specify a consistency
+// are evaluated fully consistently instead of at least as fresh as the cached zedtoken,
+// e.g. right after a write that did not go through the cache.
+// The returned zedtokens are cached.
+func WithFullConsistency(ctx context.Context) context.Context {
+	o := overrideFromContext(ctx)
+	o.consistency = fullyConsistent()
+
+	return context.WithValue(ctx, overrideKey{}, o)
+}
+
+// WithMinimizeLatency returns a copy of the context with which requests that do not specify a consistency
+// are evaluated with MinimizeLatency, so they may not reflect the latest writes.
+// The returned zedtokens are not cached, because they can be older than the latest writes.
+//
+// A maximum staleness is not supported: how stale a MinimizeLatency request can be depends on
+// SpiceDB's revision quantization, which a client can not bound.
+// Requests that specify MinimizeLatency or AtExactSnapshot themselves are sent unchanged
+// and their zedtokens are still cached, but only if they are newer than the cached ones,
+// so they never make later requests less consistent.
+func WithMinimizeLatency(ctx context.Context) context.Context {
+	o := overrideFromContext(ctx)
+	o.consistency = &pb.Consistency{Requirement: &pb.Consistency_MinimizeLatency{MinimizeLatency: true}}
+
+	return context.WithValue(ctx, overrideKey{}, o)
+}
+
+// WithoutCache returns a copy of the context with which requests neither look up nor cache zedtokens.
+// Requests that do not specify a consistency are evaluated fully consistently,
+// unless WithMinimizeLatency is used.
+// Writes still invalidate the cache, so that other requests do not use outdated zedtokens,
+// but their zedtokens are only recorded for the object types that depend on them, see WithSchema.
+func WithoutCache(ctx context.Context) context.Context {
+	o := overrideFromContext(ctx)
+	o.bypass = true
+
+	return context.WithValue(ctx, overrideKey{}, o)
+}
+
+// overridden reports whether the consistency of requests without a consistency is not taken from the cache.
+func (o override) overridden() bool {
+	return o.bypass || o.consistency != nil
+}
+
+// caches reports whether the zedtokens of responses are cached.
+func (o override) caches() bool {
+	return !o.bypass && !o.consistency.GetMinimizeLatency()
+}
+
+// apply sets the consistency of a request that does not specify one.
+func (o override) apply(consistency **pb.Consistency) {
+	if o.consistency == nil {
+		*consistency = fullyConsistent()
+		return
+	}
+	*consistency = proto.Clone(o.consistency).(*pb.Consistency)
+}
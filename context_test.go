@@ -0,0 +1,106 @@
+package zedcache
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/spicedbtest"
+)
+
+func TestConsistencyOverride(t *testing.T) {
+	ctx := context.Background()
+	post1 := &pb.ObjectReference{ObjectType: "post", ObjectId: "1"}
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+	setup := func(t *testing.T, opts ...Option) (*spicedbtest.Server, *cache.Cache, pb.PermissionsServiceClient) {
+		t.Helper()
+
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca), opts...)
+		_, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: user1},
+			}},
+		})
+		require.NoError(t, err)
+		ca.Delete("post#1")
+
+		return s, ca, c
+	}
+	check := func(t *testing.T, ctx context.Context, c pb.PermissionsServiceClient, consistency *pb.Consistency) {
+		t.Helper()
+
+		_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Consistency: consistency, Permission: "read", Resource: post1, Subject: user1})
+		require.NoError(t, err)
+	}
+	lastConsistency := func(t *testing.T, s *spicedbtest.Server, method string) *pb.Consistency {
+		t.Helper()
+
+		cs := s.Consistencies(method)
+		require.NotEmpty(t, cs)
+
+		return cs[len(cs)-1]
+	}
+
+	t.Run("full consistency", func(t *testing.T) {
+		s, ca, c := setup(t, WithSingleflight())
+
+		check(t, ctx, c, nil)
+		check(t, WithFullConsistency(ctx), c, nil)
+		assert.True(t, lastConsistency(t, s, "CheckPermission").GetFullyConsistent())
+		assert.Equal(t, s.Token(), getCacheValue(t, ca, "post#1"))
+	})
+
+	t.Run("minimize latency", func(t *testing.T) {
+		s, ca, c := setup(t)
+
+		check(t, WithMinimizeLatency(ctx), c, nil)
+		assert.True(t, lastConsistency(t, s, "CheckPermission").GetMinimizeLatency())
+		_, ok := ca.Get("post#1")
+		assert.False(t, ok)
+	})
+
+	t.Run("without cache", func(t *testing.T) {
+		s, ca, c := setup(t)
+
+		check(t, WithoutCache(ctx), c, nil)
+		assert.True(t, lastConsistency(t, s, "CheckPermission").GetFullyConsistent())
+		lr, err := c.LookupResources(WithoutCache(ctx), &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: user1})
+		require.NoError(t, err)
+		_, err = lr.Recv()
+		require.NoError(t, err)
+		assert.True(t, lastConsistency(t, s, "LookupResources").GetFullyConsistent())
+		check(t, WithMinimizeLatency(WithoutCache(ctx)), c, nil)
+		assert.True(t, lastConsistency(t, s, "CheckPermission").GetMinimizeLatency())
+		_, ok := ca.Get("post#1")
+		assert.False(t, ok)
+
+		// Writes invalidate the cache without caching their zedtokens.
+		ca.Set("post#1", s.Token(), cache.NoExpiration)
+		_, err = c.WriteRelationships(WithoutCache(ctx), &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{Resource: post1, Relation: "write", Subject: user1},
+			}},
+		})
+		require.NoError(t, err)
+		_, ok = ca.Get("post#1")
+		assert.False(t, ok)
+		check(t, ctx, c, nil)
+		assert.True(t, lastConsistency(t, s, "CheckPermission").GetFullyConsistent())
+	})
+
+	t.Run("request consistency", func(t *testing.T) {
+		s, _, c := setup(t)
+
+		check(t, WithFullConsistency(ctx), c, &pb.Consistency{Requirement: &pb.Consistency_MinimizeLatency{MinimizeLatency: true}})
+		assert.True(t, lastConsistency(t, s, "CheckPermission").GetMinimizeLatency())
+	})
+}
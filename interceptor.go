@@ -0,0 +1,378 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// Interceptor caches zedtokens for all requests to the PermissionsService that are sent on a gRPC connection.
+// It applies the same logic as the client returned by NewPermissionServiceClient,
+// so it can be used with any client for SpiceDB, e.g. generated clients or other wrappers.
+type Interceptor struct {
+	pc *permissionClient
+}
+
+// NewInterceptor creates an Interceptor that caches zedtokens in the given cache.
+func NewInterceptor(ca cache.Cache, opts ...Option) *Interceptor {
+	return &Interceptor{pc: newPermissionClient(ca, opts...)}
+}
+
+// DialOptions returns the dial options that install the Interceptor on a connection, e.g.
+//
+//	authzed.NewClient(endpoint, append(zedcache.NewInterceptor(ca).DialOptions(), opts...)...)
+func (i *Interceptor) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(i.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(i.StreamClientInterceptor()),
+	}
+}
+
+// bypassKey marks requests that were sent by the Interceptor itself and must not be intercepted again.
+type bypassKey struct{}
+
+func bypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+func bypassed(ctx context.Context) bool {
+	b, _ := ctx.Value(bypassKey{}).(bool)
+	return b
+}
+
+// UnaryClientInterceptor returns the interceptor for the unary methods of the PermissionsService.
+// Other methods are passed through.
+func (i *Interceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if bypassed(ctx) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		c := i.pc.with(&interceptedClient{cc: cc, invoker: invoker})
+
+		var res proto.Message
+		var err error
+		switch in := req.(type) {
+		case *pb.CheckPermissionRequest:
+			res, err = c.CheckPermission(ctx, in, opts...)
+		case *pb.ExpandPermissionTreeRequest:
+			res, err = c.ExpandPermissionTree(ctx, in, opts...)
+		case *pb.WriteRelationshipsRequest:
+			res, err = c.WriteRelationships(ctx, in, opts...)
+		case *pb.DeleteRelationshipsRequest:
+			res, err = c.DeleteRelationships(ctx, in, opts...)
+		default:
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if err != nil {
+			return err
+		}
+
+		return copyMessage(reply, res)
+	}
+}
+
+// StreamClientInterceptor returns the interceptor for the streaming methods of the PermissionsService.
+// Other methods are passed through.
+func (i *Interceptor) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if bypassed(ctx) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		c := i.pc.with(&interceptedClient{cc: cc, streamer: streamer})
+
+		// The request is only known once it is sent, so the upstream stream is opened by SendMsg.
+		s := &interceptedStream{ctx: ctx}
+		switch method {
+		case permissionsMethod("ReadRelationships"):
+			s.open = func(m interface{}) (grpc.ClientStream, func(interface{}) error, error) {
+				in, ok := m.(*pb.ReadRelationshipsRequest)
+				if !ok {
+					return nil, nil, errUnexpectedMessage
+				}
+				st, err := c.ReadRelationships(ctx, in, opts...)
+				if err != nil {
+					return nil, nil, err
+				}
+				return st, func(m interface{}) error {
+					res, err := st.Recv()
+					if err != nil {
+						return err
+					}
+					return copyMessage(m, res)
+				}, nil
+			}
+		case permissionsMethod("LookupResources"):
+			s.open = func(m interface{}) (grpc.ClientStream, func(interface{}) error, error) {
+				in, ok := m.(*pb.LookupResourcesRequest)
+				if !ok {
+					return nil, nil, errUnexpectedMessage
+				}
+				st, err := c.LookupResources(ctx, in, opts...)
+				if err != nil {
+					return nil, nil, err
+				}
+				return st, func(m interface{}) error {
+					res, err := st.Recv()
+					if err != nil {
+						return err
+					}
+					return copyMessage(m, res)
+				}, nil
+			}
+		case permissionsMethod("LookupSubjects"):
+			s.open = func(m interface{}) (grpc.ClientStream, func(interface{}) error, error) {
+				in, ok := m.(*pb.LookupSubjectsRequest)
+				if !ok {
+					return nil, nil, errUnexpectedMessage
+				}
+				st, err := c.LookupSubjects(ctx, in, opts...)
+				if err != nil {
+					return nil, nil, err
+				}
+				return st, func(m interface{}) error {
+					res, err := st.Recv()
+					if err != nil {
+						return err
+					}
+					return copyMessage(m, res)
+				}, nil
+			}
+		default:
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		return s, nil
+	}
+}
+
+var errUnexpectedMessage = errors.New("unexpected message type")
+
+// copyMessage replaces the message dst with src.
+func copyMessage(dst interface{}, src proto.Message) error {
+	m, ok := dst.(proto.Message)
+	if !ok {
+		return errUnexpectedMessage
+	}
+	proto.Reset(m)
+	proto.Merge(m, src)
+
+	return nil
+}
+
+// permissionsMethod returns the full name of the given method of the PermissionsService.
+func permissionsMethod(name string) string {
+	return "/" + pb.PermissionsService_ServiceDesc.ServiceName + "/" + name
+}
+
+// permissionsStream returns the descriptor of the given streaming method of the PermissionsService.
+func permissionsStream(name string) *grpc.StreamDesc {
+	for i := range pb.PermissionsService_ServiceDesc.Streams {
+		if pb.PermissionsService_ServiceDesc.Streams[i].StreamName == name {
+			return &pb.PermissionsService_ServiceDesc.Streams[i]
+		}
+	}
+
+	return &grpc.StreamDesc{StreamName: name, ServerStreams: true}
+}
+
+// interceptedStream is returned by the stream interceptor.
+// It sends the request through the cached client on the first call to SendMsg.
+type interceptedStream struct {
+	ctx context.Context
+	// open sends the request and returns the upstream stream and a function that receives its responses.
+	open func(interface{}) (grpc.ClientStream, func(interface{}) error, error)
+
+	cs   grpc.ClientStream
+	recv func(interface{}) error
+}
+
+func (s *interceptedStream) SendMsg(m interface{}) error {
+	if s.recv != nil {
+		return errors.New("the request was already sent")
+	}
+	cs, recv, err := s.open(m)
+	if err != nil {
+		return err
+	}
+	s.cs, s.recv = cs, recv
+
+	return nil
+}
+
+func (s *interceptedStream) RecvMsg(m interface{}) error {
+	if s.recv == nil {
+		return errors.New("the request was not sent")
+	}
+
+	return s.recv(m)
+}
+
+func (s *interceptedStream) CloseSend() error {
+	return nil
+}
+
+func (s *interceptedStream) Header() (metadata.MD, error) {
+	if s.cs == nil {
+		return nil, nil
+	}
+
+	return s.cs.Header()
+}
+
+func (s *interceptedStream) Trailer() metadata.MD {
+	if s.cs == nil {
+		return nil
+	}
+
+	return s.cs.Trailer()
+}
+
+func (s *interceptedStream) Context() context.Context {
+	return s.ctx
+}
+
+// interceptedClient sends the requests of the cached client to the next interceptor of the chain.
+// Requests for which no invoker or streamer is available are sent through the connection without being intercepted again,
+// e.g. streaming requests while a unary request is intercepted.
+type interceptedClient struct {
+	cc       *grpc.ClientConn
+	invoker  grpc.UnaryInvoker
+	streamer grpc.Streamer
+}
+
+func (ic *interceptedClient) invoke(ctx context.Context, name string, in, out interface{}, opts ...grpc.CallOption) error {
+	if ic.invoker == nil {
+		return ic.cc.Invoke(bypass(ctx), permissionsMethod(name), in, out, opts...)
+	}
+
+	return ic.invoker(ctx, permissionsMethod(name), in, out, ic.cc, opts...)
+}
+
+func (ic *interceptedClient) stream(ctx context.Context, name string, in interface{}, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	var cs grpc.ClientStream
+	var err error
+	if ic.streamer == nil {
+		cs, err = ic.cc.NewStream(bypass(ctx), permissionsStream(name), permissionsMethod(name), opts...)
+	} else {
+		cs, err = ic.streamer(ctx, permissionsStream(name), ic.cc, permissionsMethod(name), opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := cs.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+func (ic *interceptedClient) ReadRelationships(ctx context.Context, in *pb.ReadRelationshipsRequest, opts ...grpc.CallOption) (pb.PermissionsService_ReadRelationshipsClient, error) {
+	cs, err := ic.stream(ctx, "ReadRelationships", in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readRelationshipsClient{cs}, nil
+}
+
+func (ic *interceptedClient) WriteRelationships(ctx context.Context, in *pb.WriteRelationshipsRequest, opts ...grpc.CallOption) (*pb.WriteRelationshipsResponse, error) {
+	out := new(pb.WriteRelationshipsResponse)
+	if err := ic.invoke(ctx, "WriteRelationships", in, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (ic *interceptedClient) DeleteRelationships(ctx context.Context, in *pb.DeleteRelationshipsRequest, opts ...grpc.CallOption) (*pb.DeleteRelationshipsResponse, error) {
+	out := new(pb.DeleteRelationshipsResponse)
+	if err := ic.invoke(ctx, "DeleteRelationships", in, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (ic *interceptedClient) CheckPermission(ctx context.Context, in *pb.CheckPermissionRequest, opts ...grpc.CallOption) (*pb.CheckPermissionResponse, error) {
+	out := new(pb.CheckPermissionResponse)
+	if err := ic.invoke(ctx, "CheckPermission", in, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (ic *interceptedClient) ExpandPermissionTree(ctx context.Context, in *pb.ExpandPermissionTreeRequest, opts ...grpc.CallOption) (*pb.ExpandPermissionTreeResponse, error) {
+	out := new(pb.ExpandPermissionTreeResponse)
+	if err := ic.invoke(ctx, "ExpandPermissionTree", in, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (ic *interceptedClient) LookupResources(ctx context.Context, in *pb.LookupResourcesRequest, opts ...grpc.CallOption) (pb.PermissionsService_LookupResourcesClient, error) {
+	cs, err := ic.stream(ctx, "LookupResources", in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lookupResourcesClient{cs}, nil
+}
+
+func (ic *interceptedClient) LookupSubjects(ctx context.Context, in *pb.LookupSubjectsRequest, opts ...grpc.CallOption) (pb.PermissionsService_LookupSubjectsClient, error) {
+	cs, err := ic.stream(ctx, "LookupSubjects", in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lookupSubjectsClient{cs}, nil
+}
+
+type readRelationshipsClient struct {
+	grpc.ClientStream
+}
+
+func (x *readRelationshipsClient) Recv() (*pb.ReadRelationshipsResponse, error) {
+	m := new(pb.ReadRelationshipsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+type lookupResourcesClient struct {
+	grpc.ClientStream
+}
+
+func (x *lookupResourcesClient) Recv() (*pb.LookupResourcesResponse, error) {
+	m := new(pb.LookupResourcesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+type lookupSubjectsClient struct {
+	grpc.ClientStream
+}
+
+func (x *lookupSubjectsClient) Recv() (*pb.LookupSubjectsResponse, error) {
+	m := new(pb.LookupSubjectsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,128 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/authzed/authzed-go/v1"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/internal/proxy"
+	"github.com/connylabs/zedcache/spicedbtest"
+)
+
+// dialFake serves the fake on an in-memory connection and returns a client that uses the Interceptor.
+func dialFake(t *testing.T, s *spicedbtest.Server, i *Interceptor) *authzed.Client {
+	t.Helper()
+
+	srv := grpc.NewServer()
+	proxy.Register(srv, s.Client(), "")
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	c, err := authzed.NewClient("bufnet", append(i.DialOptions(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)...)
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestInterceptor(t *testing.T) {
+	ctx := context.Background()
+	post1 := &pb.ObjectReference{ObjectType: "post", ObjectId: "1"}
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+
+	s := spicedbtest.New()
+	ca := gcache.New(gcache.NoExpiration, gcache.NoExpiration)
+	c := dialFake(t, s, NewInterceptor(gocache.New(ca)))
+
+	check, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Resource: post1, Permission: "read", Subject: user1})
+	require.NoError(t, err)
+	assert.Equal(t, s.Token(), check.CheckedAt.Token)
+	assert.Equal(t, s.Token(), getCacheValue(t, ca, "post#1"))
+
+	wr, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+		Updates: []*pb.RelationshipUpdate{{
+			Operation:    pb.RelationshipUpdate_OPERATION_CREATE,
+			Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: user1},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, wr.WrittenAt.Token, getCacheValue(t, ca, "post#1"))
+	assert.Equal(t, wr.WrittenAt.Token, getCacheValue(t, ca, "user#1"))
+
+	check, err = c.CheckPermission(ctx, &pb.CheckPermissionRequest{Resource: post1, Permission: "read", Subject: user1})
+	require.NoError(t, err)
+	assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, check.Permissionship)
+
+	lr, err := c.LookupResources(ctx, &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: user1})
+	require.NoError(t, err)
+	var ids []string
+	for {
+		res, err := lr.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		ids = append(ids, res.ResourceObjectId)
+	}
+	assert.Equal(t, []string{"1"}, ids)
+
+	cs := s.Consistencies("CheckPermission")
+	require.Len(t, cs, 2)
+	assert.True(t, cs[0].GetFullyConsistent())
+	assert.Equal(t, wr.WrittenAt.Token, cs[1].GetAtLeastAsFresh().GetToken())
+	// The dependency key of the user type was not cached yet.
+	cs = s.Consistencies("LookupResources")
+	require.Len(t, cs, 1)
+	assert.True(t, cs[0].GetFullyConsistent())
+	assert.Equal(t, s.Token(), getCacheValue(t, ca, "->user"))
+
+	t.Run("stale token", func(t *testing.T) {
+		s.ResetRequests()
+		stale := getCacheValue(t, ca, "post#1")
+		_, err := s.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{})
+		require.NoError(t, err)
+		s.GarbageCollect()
+
+		ls, err := c.LookupSubjects(ctx, &pb.LookupSubjectsRequest{Resource: post1, Permission: "read", SubjectObjectType: "user"})
+		require.NoError(t, err)
+		res, err := ls.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, "1", res.SubjectObjectId)
+
+		cs := s.Consistencies("LookupSubjects")
+		require.Len(t, cs, 2)
+		assert.Equal(t, stale, cs[0].GetAtLeastAsFresh().GetToken())
+		assert.True(t, cs[1].GetFullyConsistent())
+		assert.Equal(t, s.Token(), getCacheValue(t, ca, "post#1"))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s.ResetRequests()
+		dr, err := c.DeleteRelationships(ctx, &pb.DeleteRelationshipsRequest{
+			RelationshipFilter: &pb.RelationshipFilter{ResourceType: "post", OptionalResourceId: "1"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, dr.DeletedAt.Token, getCacheValue(t, ca, "post#1"))
+		assert.Equal(t, dr.DeletedAt.Token, getCacheValue(t, ca, "type:post"))
+
+		// The keys to invalidate are derived from the filter without reading the relationships.
+		assert.Empty(t, s.Consistencies("ReadRelationships"))
+	})
+}
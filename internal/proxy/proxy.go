@@ -0,0 +1,159 @@
+// Package proxy serves SpiceDB's services on a gRPC server by forwarding all requests to an authzed.Client,
+// e.g. one that caches zedtokens with zedcache.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/authzed/authzed-go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Register registers the PermissionsService, SchemaService and WatchService of the client with the gRPC server.
+// Services whose client is nil are not registered.
+// The metadata of incoming requests is forwarded to the client.
+// If token is not empty, it is sent as the authorization header of requests that do not carry their own.
+func Register(s *grpc.Server, c *authzed.Client, token string) {
+	if c.PermissionsServiceClient != nil {
+		pb.RegisterPermissionsServiceServer(s, &permissionsServer{c: c.PermissionsServiceClient, token: token})
+	}
+	if c.SchemaServiceClient != nil {
+		pb.RegisterSchemaServiceServer(s, &schemaServer{c: c.SchemaServiceClient, token: token})
+	}
+	if c.WatchServiceClient != nil {
+		pb.RegisterWatchServiceServer(s, &watchServer{c: c.WatchServiceClient, token: token})
+	}
+}
+
+// outgoing forwards the metadata of the incoming request, e.g. the authorization header, to the upstream.
+// If the request does not carry an authorization header and token is not empty, token is sent instead.
+func outgoing(ctx context.Context, token string) context.Context {
+	in, _ := metadata.FromIncomingContext(ctx)
+	md := metadata.MD{}
+	for k, v := range in {
+		// Skip the headers of the incoming connection.
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") || k == "content-type" || k == "user-agent" {
+			continue
+		}
+		md[k] = v
+	}
+	if len(md.Get("authorization")) == 0 && token != "" {
+		md.Set("authorization", "Bearer "+token)
+	}
+
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// forward sends every message that is received from the upstream stream to the downstream stream.
+func forward[T any](recv func() (T, error), send func(T) error) error {
+	for {
+		res, err := recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := send(res); err != nil {
+			return err
+		}
+	}
+}
+
+type permissionsServer struct {
+	pb.UnimplementedPermissionsServiceServer
+
+	c     pb.PermissionsServiceClient
+	token string
+}
+
+func (s *permissionsServer) ReadRelationships(in *pb.ReadRelationshipsRequest, stream pb.PermissionsService_ReadRelationshipsServer) error {
+	ctx, cancel := context.WithCancel(outgoing(stream.Context(), s.token))
+	defer cancel()
+
+	upstream, err := s.c.ReadRelationships(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	return forward(upstream.Recv, stream.Send)
+}
+
+func (s *permissionsServer) WriteRelationships(ctx context.Context, in *pb.WriteRelationshipsRequest) (*pb.WriteRelationshipsResponse, error) {
+	return s.c.WriteRelationships(outgoing(ctx, s.token), in)
+}
+
+func (s *permissionsServer) DeleteRelationships(ctx context.Context, in *pb.DeleteRelationshipsRequest) (*pb.DeleteRelationshipsResponse, error) {
+	return s.c.DeleteRelationships(outgoing(ctx, s.token), in)
+}
+
+func (s *permissionsServer) CheckPermission(ctx context.Context, in *pb.CheckPermissionRequest) (*pb.CheckPermissionResponse, error) {
+	return s.c.CheckPermission(outgoing(ctx, s.token), in)
+}
+
+func (s *permissionsServer) ExpandPermissionTree(ctx context.Context, in *pb.ExpandPermissionTreeRequest) (*pb.ExpandPermissionTreeResponse, error) {
+	return s.c.ExpandPermissionTree(outgoing(ctx, s.token), in)
+}
+
+func (s *permissionsServer) LookupResources(in *pb.LookupResourcesRequest, stream pb.PermissionsService_LookupResourcesServer) error {
+	ctx, cancel := context.WithCancel(outgoing(stream.Context(), s.token))
+	defer cancel()
+
+	upstream, err := s.c.LookupResources(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	return forward(upstream.Recv, stream.Send)
+}
+
+func (s *permissionsServer) LookupSubjects(in *pb.LookupSubjectsRequest, stream pb.PermissionsService_LookupSubjectsServer) error {
+	ctx, cancel := context.WithCancel(outgoing(stream.Context(), s.token))
+	defer cancel()
+
+	upstream, err := s.c.LookupSubjects(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	return forward(upstream.Recv, stream.Send)
+}
+
+type schemaServer struct {
+	pb.UnimplementedSchemaServiceServer
+
+	c     pb.SchemaServiceClient
+	token string
+}
+
+func (s *schemaServer) ReadSchema(ctx context.Context, in *pb.ReadSchemaRequest) (*pb.ReadSchemaResponse, error) {
+	return s.c.ReadSchema(outgoing(ctx, s.token), in)
+}
+
+func (s *schemaServer) WriteSchema(ctx context.Context, in *pb.WriteSchemaRequest) (*pb.WriteSchemaResponse, error) {
+	return s.c.WriteSchema(outgoing(ctx, s.token), in)
+}
+
+type watchServer struct {
+	pb.UnimplementedWatchServiceServer
+
+	c     pb.WatchServiceClient
+	token string
+}
+
+func (s *watchServer) Watch(in *pb.WatchRequest, stream pb.WatchService_WatchServer) error {
+	ctx, cancel := context.WithCancel(outgoing(stream.Context(), s.token))
+	defer cancel()
+
+	upstream, err := s.c.Watch(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	return forward(upstream.Recv, stream.Send)
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/authzed/authzed-go/v1"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/connylabs/zedcache"
+	gocache "github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/spicedbtest"
+)
+
+func TestProxy(t *testing.T) {
+	upstream := spicedbtest.New()
+	s := grpc.NewServer()
+	ca := gcache.New(gcache.NoExpiration, gcache.NoExpiration)
+	// Requests for object types without a cached dependency key are evaluated fully consistently.
+	for _, k := range []string{"->post", "->user"} {
+		ca.Set(k, upstream.Token(), gcache.NoExpiration)
+	}
+	Register(s, zedcache.New(upstream.Client(), gocache.New(ca)), "")
+
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	c, err := authzed.NewClient("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	post1 := &pb.ObjectReference{ObjectType: "post", ObjectId: "1"}
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+	res, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+		Updates: []*pb.RelationshipUpdate{{
+			Operation:    pb.RelationshipUpdate_OPERATION_CREATE,
+			Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: user1},
+		}},
+	})
+	require.NoError(t, err)
+
+	check, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Resource: post1, Permission: "read", Subject: user1})
+	require.NoError(t, err)
+	assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, check.Permissionship)
+
+	lr, err := c.LookupResources(ctx, &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: user1})
+	require.NoError(t, err)
+	var ids []string
+	for {
+		r, err := lr.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		ids = append(ids, r.ResourceObjectId)
+	}
+	assert.Equal(t, []string{"1"}, ids)
+
+	// The proxy sent the zedtoken of the write to the upstream.
+	cs := upstream.Consistencies("CheckPermission")
+	require.Len(t, cs, 1)
+	assert.Equal(t, res.WrittenAt.Token, cs[0].GetAtLeastAsFresh().GetToken())
+	cs = upstream.Consistencies("LookupResources")
+	require.Len(t, cs, 1)
+	assert.Equal(t, res.WrittenAt.Token, cs[0].GetAtLeastAsFresh().GetToken())
+
+	// Errors of the upstream are passed through.
+	_, err = c.CheckPermission(ctx, &pb.CheckPermissionRequest{
+		Consistency: &pb.Consistency{Requirement: &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: "invalid"}}},
+		Resource:    post1,
+		Permission:  "read",
+		Subject:     user1,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The SchemaService is passed through to the upstream.
+	_, err = c.ReadSchema(ctx, &pb.ReadSchemaRequest{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = c.WriteSchema(ctx, &pb.WriteSchemaRequest{Schema: "definition user {}"})
+	require.NoError(t, err)
+	schema, err := c.ReadSchema(ctx, &pb.ReadSchemaRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "definition user {}", schema.SchemaText)
+}
+
+func TestOutgoing(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		in    metadata.MD
+		token string
+		want  metadata.MD
+	}{
+		{
+			name: "empty",
+			want: metadata.MD{},
+		},
+		{
+			name: "forward authorization",
+			in:   metadata.Pairs("authorization", "Bearer foo", "content-type", "application/grpc", "x-request-id", "1"),
+			want: metadata.Pairs("authorization", "Bearer foo", "x-request-id", "1"),
+		},
+		{
+			name:  "default token",
+			in:    metadata.Pairs("x-request-id", "1"),
+			token: "bar",
+			want:  metadata.Pairs("authorization", "Bearer bar", "x-request-id", "1"),
+		},
+		{
+			name:  "caller token",
+			in:    metadata.Pairs("authorization", "Bearer foo"),
+			token: "bar",
+			want:  metadata.Pairs("authorization", "Bearer foo"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tc.in)
+			md, ok := metadata.FromOutgoingContext(outgoing(ctx, tc.token))
+			require.True(t, ok)
+			assert.Equal(t, tc.want, md)
+		})
+	}
+}
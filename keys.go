@@ -0,0 +1,187 @@
+package zedcache
+
+import (
+	"context"
+	"strings"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+)
+
+// KeyKind is the kind of a Key.
+type KeyKind int
+
+const (
+	// ObjectKey is the key of an object, a subject set or a wildcard subject.
+	ObjectKey KeyKind = iota
+	// TypeKey is the key of all objects of an object type.
+	// Every write advances the zedtokens of the object types of its resources and subjects.
+	TypeKey
+	// DependencyKey is the key of an object type whose permissions depend on relationships of other objects, see WithSchema,
+	// or whose relationships were deleted by a filter that does not name the resource.
+	// A missing DependencyKey is a cache miss for all objects of the type.
+	DependencyKey
+)
+
+// Key identifies a cached zedtoken.
+type Key struct {
+	Kind       KeyKind
+	ObjectType string
+	// ObjectID is the id of the object. It is "*" for wildcard subjects, e.g. user:*, and empty for other kinds than ObjectKey.
+	ObjectID string
+	// Relation is the relation of a subject set, e.g. member for group:eng#member. It is empty for objects.
+	Relation string
+}
+
+// KeyFunc encodes a Key as a cache key.
+// Different Keys must be encoded as different cache keys.
+type KeyFunc func(Key) string
+
+// WithKeyFunc sets the function that encodes the keys of cached zedtokens.
+// All clients and Watchers that share a cache must use the same KeyFunc.
+// By default DefaultKeyFunc is used.
+func WithKeyFunc(f KeyFunc) Option {
+	return func(pc *permissionClient) {
+		pc.kf = f
+	}
+}
+
+// WithWatchKeyFunc sets the function that encodes the keys of the zedtokens written by the Watcher, see WithKeyFunc.
+func WithWatchKeyFunc(f KeyFunc) WatchOption {
+	return func(w *Watcher) {
+		w.kf = f
+	}
+}
+
+// WithNamespace prefixes all cache keys with the namespace,
+// e.g. to share a cache between several SpiceDB instances or environments.
+func WithNamespace(ns string) Option {
+	return WithNamespaceFunc(func(context.Context) string {
+		return ns
+	})
+}
+
+// WithNamespaceFunc prefixes the cache keys of every request with the namespace that the function returns for the request's context,
+// e.g. the tenant of the request. The keys of requests with an empty namespace are prefixed with `:`.
+// All clients and Watchers that share a cache must either use namespaces or not.
+// Writes only invalidate the keys of their own namespace, so requests may only use different namespaces
+// if they can not observe each other's writes, e.g. because they are sent to different SpiceDB instances.
+func WithNamespaceFunc(f func(context.Context) string) Option {
+	return func(pc *permissionClient) {
+		pc.ns = f
+	}
+}
+
+// WithWatchNamespace prefixes the cache keys of the zedtokens written by the Watcher with the namespace, see WithNamespace.
+func WithWatchNamespace(ns string) WatchOption {
+	return func(w *Watcher) {
+		w.ns = &ns
+	}
+}
+
+var (
+	escaper   = strings.NewReplacer("%", "%25", "#", "%23")
+	idEscaper = strings.NewReplacer("%", "%25", "#", "%23", "*", "%2A")
+	nsEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+)
+
+// keyFunc returns the KeyFunc for a request, which prefixes the keys with the request's namespace.
+func (c *permissionClient) keyFunc(ctx context.Context) KeyFunc {
+	if c.ns == nil {
+		return c.kf
+	}
+
+	return namespaced(c.ns(ctx), c.kf)
+}
+
+// namespaced prefixes the keys of the KeyFunc with `namespace:`, even if the namespace is empty.
+// ':' is percent-encoded in the namespace, so the prefix ends at the first ':' and keys of different namespaces can not collide,
+// whatever the KeyFunc returns.
+func namespaced(ns string, kf KeyFunc) KeyFunc {
+	prefix := nsEscaper.Replace(ns) + ":"
+
+	return func(k Key) string {
+		return prefix + kf(k)
+	}
+}
+
+// DefaultKeyFunc encodes objects as `type#id`, subject sets as `type#id#relation` and wildcard subjects as `type#*`.
+// Object types are encoded as `type:type` and dependency keys as `->type`.
+// '%' and '#' are percent-encoded in all parts, and '*' in ids, so that the encoding is unambiguous:
+// object keys always contain one or two '#' and the keys of the other kinds none.
+func DefaultKeyFunc(k Key) string {
+	switch k.Kind {
+	case TypeKey:
+		return "type:" + escaper.Replace(k.ObjectType)
+	case DependencyKey:
+		return "->" + escaper.Replace(k.ObjectType)
+	}
+	id := k.ObjectID
+	if id != "*" {
+		id = idEscaper.Replace(id)
+	}
+	key := escaper.Replace(k.ObjectType) + "#" + id
+	if k.Relation != "" {
+		key += "#" + escaper.Replace(k.Relation)
+	}
+
+	return key
+}
+
+func objectKey(r *pb.ObjectReference) Key {
+	if r == nil {
+		panic("can not print nil object reference")
+	}
+
+	return Key{ObjectType: r.ObjectType, ObjectID: r.ObjectId}
+}
+
+func subjectKey(r *pb.SubjectReference) Key {
+	if r == nil || r.Object == nil {
+		panic("can not print nil object reference")
+	}
+	k := objectKey(r.Object)
+	k.Relation = r.OptionalRelation
+
+	return k
+}
+
+func typeKey(objectType string) Key {
+	return Key{Kind: TypeKey, ObjectType: objectType}
+}
+
+func dependencyKey(objectType string) Key {
+	return Key{Kind: DependencyKey, ObjectType: objectType}
+}
+
+// encodeKeys encodes all keys with the KeyFunc.
+func encodeKeys(kf KeyFunc, keys []Key) []string {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = kf(k)
+	}
+
+	return s
+}
+
+// relationshipKeys returns the cache keys of the resources and subjects of the given updates and of their object types.
+func relationshipKeys(kf KeyFunc, updates []*pb.RelationshipUpdate) []string {
+	keys := make([]string, 0, 2*len(updates))
+	types := make(map[string]struct{})
+	for _, u := range updates {
+		r := u.Relationship
+		keys = append(keys, kf(objectKey(r.Resource)), kf(subjectKey(r.Subject)))
+		types[r.Resource.ObjectType] = struct{}{}
+		types[r.Subject.Object.ObjectType] = struct{}{}
+	}
+
+	return appendTypeKeys(kf, keys, types)
+}
+
+// appendTypeKeys appends the cache keys of the given object types.
+func appendTypeKeys(kf KeyFunc, keys []string, types map[string]struct{}) []string {
+	for t := range types {
+		keys = append(keys, kf(typeKey(t)))
+	}
+
+	return keys
+}
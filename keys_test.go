@@ -0,0 +1,38 @@
+package zedcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultKeyFunc(t *testing.T) {
+	for _, tc := range []struct {
+		key      Key
+		expected string
+	}{
+		{key: Key{ObjectType: "post", ObjectID: "1"}, expected: "post#1"},
+		{key: Key{ObjectType: "group", ObjectID: "eng", Relation: "member"}, expected: "group#eng#member"},
+		{key: Key{ObjectType: "user", ObjectID: "*"}, expected: "user#*"},
+		{key: Key{ObjectType: "tenant/post", ObjectID: "a|b"}, expected: "tenant/post#a|b"},
+		// Ids with '#' can not be confused with subject sets.
+		{key: Key{ObjectType: "group", ObjectID: "eng#member"}, expected: "group#eng%23member"},
+		{key: Key{ObjectType: "user", ObjectID: "100%"}, expected: "user#100%25"},
+		{key: Key{ObjectType: "user", ObjectID: "a*"}, expected: "user#a%2A"},
+		{key: Key{Kind: TypeKey, ObjectType: "post"}, expected: "type:post"},
+		{key: Key{Kind: DependencyKey, ObjectType: "post"}, expected: "->post"},
+	} {
+		assert.Equal(t, tc.expected, DefaultKeyFunc(tc.key), "%+v", tc.key)
+	}
+}
+
+func TestNamespaced(t *testing.T) {
+	k := Key{ObjectType: "post", ObjectID: "1"}
+	assert.Equal(t, ":post#1", namespaced("", DefaultKeyFunc)(k))
+	assert.Equal(t, "prod:post#1", namespaced("prod", DefaultKeyFunc)(k))
+	// Namespaces with ':' can not collide with other namespaces.
+	assert.Equal(t, "eu%3Aprod:post#1", namespaced("eu:prod", DefaultKeyFunc)(k))
+	assert.Equal(t, "100%25:post#1", namespaced("100%", DefaultKeyFunc)(k))
+	// Keys with ':' can not collide with keys of other namespaces.
+	assert.NotEqual(t, namespaced("", DefaultKeyFunc)(Key{ObjectType: "a:b", ObjectID: "1"}), namespaced("a", DefaultKeyFunc)(Key{ObjectType: "b", ObjectID: "1"}))
+}
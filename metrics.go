@@ -0,0 +1,302 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// WithRegisterer registers the client's metrics with the given prometheus.Registerer.
+// Clients and interceptors that share a registerer share their metrics.
+// By default no metrics are registered.
+func WithRegisterer(r prometheus.Registerer) Option {
+	return func(pc *permissionClient) {
+		pc.r = r
+	}
+}
+
+// WithBackendName sets the value of the backend label of the cache metrics.
+// By default the name is derived from the type of the cache, e.g. "gocache.Cache".
+func WithBackendName(name string) Option {
+	return func(pc *permissionClient) {
+		pc.backend = name
+	}
+}
+
+type metrics struct {
+	staleTokens       *prometheus.CounterVec
+	lookups           *prometheus.CounterVec
+	invalidations     *prometheus.CounterVec
+	requests          *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	operations        *prometheus.CounterVec
+	operationDuration *prometheus.HistogramVec
+	coalesced         *prometheus.CounterVec
+	circuitOpen       *prometheus.GaugeVec
+}
+
+// register registers the collector with r if r is not nil.
+// If an equal collector is already registered, e.g. by another client, the existing collector is returned instead.
+func register[T prometheus.Collector](r prometheus.Registerer, c T) T {
+	if r == nil {
+		return c
+	}
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+
+	return c
+}
+
+// newMetrics creates the metrics and registers them if r is not nil.
+func newMetrics(r prometheus.Registerer) *metrics {
+	return &metrics{
+		staleTokens: register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zedcache_stale_tokens_total",
+			Help: "Number of requests that failed because the cached zedtoken was expired or invalid and were retried with the fallback consistency.",
+		}, []string{"method"})),
+		lookups: register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zedcache_lookups_total",
+			Help: "Number of zedtokens that were looked up in the cache by result.",
+		}, []string{"method", "backend", "result"})),
+		invalidations: register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zedcache_invalidations_total",
+			Help: "Number of cache entries that were deleted to avoid the New Enemy problem.",
+		}, []string{"method", "backend"})),
+		requests: register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zedcache_requests_total",
+			Help: "Number of requests sent to SpiceDB by consistency requirement and result.",
+		}, []string{"method", "consistency", "result"})),
+		requestDuration: register(r, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "zedcache_request_duration_seconds",
+			Help:    "Duration of requests sent to SpiceDB. For streams, the duration until the stream was established.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"})),
+		operations: register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zedcache_cache_operations_total",
+			Help: "Number of operations on the cache backend by result.",
+		}, []string{"backend", "operation", "result"})),
+		operationDuration: register(r, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "zedcache_cache_operation_duration_seconds",
+			Help:    "Duration of operations on the cache backend.",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"backend", "operation"})),
+		coalesced: register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zedcache_coalesced_requests_total",
+			Help: "Number of requests that missed the cache and waited for a concurrent request for the same key instead of being sent fully consistently.",
+		}, []string{"method"})),
+		circuitOpen: register(r, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "zedcache_cache_circuit_open",
+			Help: "Whether the circuit breaker of the cache backend is open.",
+		}, []string{"backend"})),
+	}
+}
+
+// backendName derives the name of a cache backend from its type.
+func backendName(ca cache.Cache) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", ca), "*")
+}
+
+// lookup records the result of looking up a zedtoken in the cache.
+func (m *metrics) lookup(method, backend string, err error) {
+	result := "hit"
+	switch {
+	case errors.Is(err, cache.ErrCacheMiss):
+		result = "miss"
+	case err != nil:
+		result = "error"
+	}
+	m.lookups.WithLabelValues(method, backend, result).Inc()
+}
+
+// consistencyLabel returns the name of the given consistency requirement.
+func consistencyLabel(c *pb.Consistency) string {
+	switch c.GetRequirement().(type) {
+	case *pb.Consistency_FullyConsistent:
+		return "fully_consistent"
+	case *pb.Consistency_AtLeastAsFresh:
+		return "at_least_as_fresh"
+	case *pb.Consistency_AtExactSnapshot:
+		return "at_exact_snapshot"
+	case *pb.Consistency_MinimizeLatency:
+		return "minimize_latency"
+	default:
+		return "none"
+	}
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
+
+// instrumentedCache records the metrics of every operation on the wrapped cache.
+type instrumentedCache struct {
+	c       cache.ContextCache
+	m       *metrics
+	backend string
+}
+
+var (
+	_ cache.BatchCache     = &instrumentedCache{}
+	_ cache.ContextCache   = &instrumentedCache{}
+	_ cache.MonotonicCache = &instrumentedCache{}
+	_ cache.TTLCache       = &instrumentedCache{}
+)
+
+func (ic *instrumentedCache) observe(operation string, start time.Time, err error) {
+	if errors.Is(err, cache.ErrCacheMiss) {
+		err = nil
+	}
+	ic.m.operations.WithLabelValues(ic.backend, operation, resultLabel(err)).Inc()
+	ic.m.operationDuration.WithLabelValues(ic.backend, operation).Observe(time.Since(start).Seconds())
+}
+
+func (ic *instrumentedCache) GetContext(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	v, err := ic.c.GetContext(ctx, key)
+	ic.observe("get", start, err)
+
+	return v, err
+}
+
+func (ic *instrumentedCache) SetContext(ctx context.Context, key, value string) error {
+	start := time.Now()
+	err := ic.c.SetContext(ctx, key, value)
+	ic.observe("set", start, err)
+
+	return err
+}
+
+func (ic *instrumentedCache) DelContext(ctx context.Context, keys ...string) error {
+	start := time.Now()
+	err := ic.c.DelContext(ctx, keys...)
+	ic.observe("del", start, err)
+
+	return err
+}
+
+func (ic *instrumentedCache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	start := time.Now()
+	err := cache.SetWithTTL(ctx, ic.c, key, value, ttl)
+	ic.observe("set", start, err)
+
+	return err
+}
+
+func (ic *instrumentedCache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	start := time.Now()
+	err := cache.SetIfNewer(ctx, ic.c, key, value, ttl, cmp)
+	ic.observe("set_if_newer", start, err)
+
+	return err
+}
+
+func (ic *instrumentedCache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	start := time.Now()
+	v, err := cache.GetMulti(ctx, ic.c, keys...)
+	ic.observe("get_multi", start, err)
+
+	return v, err
+}
+
+func (ic *instrumentedCache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	start := time.Now()
+	err := cache.SetMulti(ctx, ic.c, values, ttl, cmp)
+	ic.observe("set_multi", start, err)
+
+	return err
+}
+
+// instrumentedClient records the metrics of every request sent to SpiceDB.
+type instrumentedClient struct {
+	pb.PermissionsServiceClient
+
+	m *metrics
+}
+
+// observe records the metrics of a request and adds the consistency requirement that was sent to the current span.
+func (ic *instrumentedClient) observe(ctx context.Context, method string, consistency *pb.Consistency, start time.Time, err error) {
+	ic.m.requests.WithLabelValues(method, consistencyLabel(consistency), resultLabel(err)).Inc()
+	ic.m.requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	span := trace.SpanFromContext(ctx)
+	if consistency != nil {
+		span.SetAttributes(consistencyAttribute.String(consistencyLabel(consistency)))
+	}
+	recordError(span, err)
+}
+
+func (ic *instrumentedClient) ReadRelationships(ctx context.Context, in *pb.ReadRelationshipsRequest, opts ...grpc.CallOption) (pb.PermissionsService_ReadRelationshipsClient, error) {
+	start := time.Now()
+	ret, err := ic.PermissionsServiceClient.ReadRelationships(ctx, in, opts...)
+	ic.observe(ctx, "ReadRelationships", in.Consistency, start, err)
+
+	return ret, err
+}
+
+func (ic *instrumentedClient) WriteRelationships(ctx context.Context, in *pb.WriteRelationshipsRequest, opts ...grpc.CallOption) (*pb.WriteRelationshipsResponse, error) {
+	start := time.Now()
+	ret, err := ic.PermissionsServiceClient.WriteRelationships(ctx, in, opts...)
+	ic.observe(ctx, "WriteRelationships", nil, start, err)
+
+	return ret, err
+}
+
+func (ic *instrumentedClient) DeleteRelationships(ctx context.Context, in *pb.DeleteRelationshipsRequest, opts ...grpc.CallOption) (*pb.DeleteRelationshipsResponse, error) {
+	start := time.Now()
+	ret, err := ic.PermissionsServiceClient.DeleteRelationships(ctx, in, opts...)
+	ic.observe(ctx, "DeleteRelationships", nil, start, err)
+
+	return ret, err
+}
+
+func (ic *instrumentedClient) CheckPermission(ctx context.Context, in *pb.CheckPermissionRequest, opts ...grpc.CallOption) (*pb.CheckPermissionResponse, error) {
+	start := time.Now()
+	ret, err := ic.PermissionsServiceClient.CheckPermission(ctx, in, opts...)
+	ic.observe(ctx, "CheckPermission", in.Consistency, start, err)
+
+	return ret, err
+}
+
+func (ic *instrumentedClient) ExpandPermissionTree(ctx context.Context, in *pb.ExpandPermissionTreeRequest, opts ...grpc.CallOption) (*pb.ExpandPermissionTreeResponse, error) {
+	start := time.Now()
+	ret, err := ic.PermissionsServiceClient.ExpandPermissionTree(ctx, in, opts...)
+	ic.observe(ctx, "ExpandPermissionTree", in.Consistency, start, err)
+
+	return ret, err
+}
+
+func (ic *instrumentedClient) LookupResources(ctx context.Context, in *pb.LookupResourcesRequest, opts ...grpc.CallOption) (pb.PermissionsService_LookupResourcesClient, error) {
+	start := time.Now()
+	ret, err := ic.PermissionsServiceClient.LookupResources(ctx, in, opts...)
+	ic.observe(ctx, "LookupResources", in.Consistency, start, err)
+
+	return ret, err
+}
+
+func (ic *instrumentedClient) LookupSubjects(ctx context.Context, in *pb.LookupSubjectsRequest, opts ...grpc.CallOption) (pb.PermissionsService_LookupSubjectsClient, error) {
+	start := time.Now()
+	ret, err := ic.PermissionsServiceClient.LookupSubjects(ctx, in, opts...)
+	ic.observe(ctx, "LookupSubjects", in.Consistency, start, err)
+
+	return ret, err
+}
@@ -0,0 +1,321 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/sony/gobreaker"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/zedtoken"
+)
+
+// Policy decides how a request proceeds if an operation on the cache fails.
+type Policy int
+
+const (
+	// Block fails the request.
+	Block Policy = iota
+	// Degrade proceeds with the request, but evaluates the affected requests fully consistently.
+	Degrade
+	// Proceed ignores the error.
+	Proceed
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case Degrade:
+		return "degrade"
+	case Proceed:
+		return "proceed"
+	default:
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+}
+
+// WithGetPolicy sets the Policy for lookups of cached zedtokens that fail with an error other than a cache miss.
+// Block fails the request.
+// Degrade evaluates the request fully consistently, like a cache miss. This is the default.
+// Proceed sends the request without a consistency requirement, so SpiceDB evaluates it with its default consistency,
+// which can lead to the New Enemy problem.
+func WithGetPolicy(p Policy) Option {
+	return func(pc *permissionClient) {
+		pc.getPolicy = p
+	}
+}
+
+// WithSetPolicy sets the Policy for zedtokens that could not be written to the cache after a request.
+// Block fails the request, even though SpiceDB already processed it.
+// Degrade deletes the cached zedtoken, so that the following requests are evaluated fully consistently.
+// Proceed logs the error. This is the default.
+func WithSetPolicy(p Policy) Option {
+	return func(pc *permissionClient) {
+		pc.setPolicy = p
+	}
+}
+
+// WithDelPolicy sets the Policy for zedtokens that could not be deleted from the cache before relationships are written or deleted.
+// Block fails the request before it is sent to SpiceDB. This is the default.
+// Degrade sends the request and evaluates all requests for the affected resources and subjects fully consistently,
+// until their zedtokens were deleted or replaced by a following write. The affected keys are only kept in memory,
+// so other clients that share the cache can still use the old zedtokens.
+// Proceed sends the request and logs the error. Requests can still be evaluated at the old zedtokens,
+// which can lead to the New Enemy problem.
+func WithDelPolicy(p Policy) Option {
+	return func(pc *permissionClient) {
+		pc.delPolicy = p
+	}
+}
+
+// ErrCircuitOpen is returned for operations on the cache while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+// WithCircuitBreaker stops sending operations to the cache after the given number of consecutive failures.
+// While the circuit breaker is open, operations fail with ErrCircuitOpen and are handled according to the configured Policy,
+// i.e. by default requests are evaluated fully consistently without looking up the cache.
+// After the timeout a single operation is let through to probe whether the cache recovered.
+func WithCircuitBreaker(failures uint32, timeout time.Duration) Option {
+	return func(pc *permissionClient) {
+		pc.breaker = &gobreaker.Settings{
+			Timeout: timeout,
+			ReadyToTrip: func(c gobreaker.Counts) bool {
+				return c.ConsecutiveFailures >= failures
+			},
+		}
+	}
+}
+
+// lookupFailed handles a failed lookup of a zedtoken according to the Get policy.
+func (c *permissionClient) lookupFailed(err error) error {
+	switch c.getPolicy {
+	case Block:
+		return fmt.Errorf("failed to look up zedtoken: %w", err)
+	case Proceed:
+		level.Warn(c.l).Log("msg", "failed to look up zedtoken, proceeding without consistency requirement", "err", err.Error())
+	}
+
+	return nil
+}
+
+// setFailed handles zedtokens that could not be written to the cache according to the Set policy.
+func (c *permissionClient) setFailed(ctx context.Context, err error, keys ...string) error {
+	if err == nil {
+		return nil
+	}
+	switch c.setPolicy {
+	case Block:
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	case Degrade:
+		if err := c.ca.DelContext(ctx, keys...); err != nil {
+			level.Error(c.l).Log("msg", "failed to delete cache entry", "err", err.Error())
+		}
+	}
+	level.Error(c.l).Log("msg", "failed to write cache entry", "err", err.Error())
+
+	return nil
+}
+
+// set caches the token for the given keys, unless a newer token is already cached or the context disables caching.
+func (c *permissionClient) set(ctx context.Context, token string, ks ...Key) error {
+	if !overrideFromContext(ctx).caches() {
+		return nil
+	}
+	keys := encodeKeys(c.keyFunc(ctx), ks)
+	if len(keys) == 1 {
+		return c.setFailed(ctx, cache.SetIfNewer(ctx, c.ca, keys[0], token, c.ttl, zedtoken.Compare), keys...)
+	}
+
+	return c.setFailed(ctx, setAll(ctx, c.ca, c.ttl, keys, token), keys...)
+}
+
+// invalidate deletes the cached zedtokens of the given keys before relationships are written or deleted.
+// Errors are handled according to the Del policy.
+func (c *permissionClient) invalidate(ctx context.Context, method string, keys []string) error {
+	err := c.ca.DelContext(ctx, keys...)
+	c.forget(keys...)
+	if err == nil {
+		c.dk.remove(keys...)
+		c.m.invalidations.WithLabelValues(method, c.backend).Add(float64(len(keys)))
+		return nil
+	}
+	switch c.delPolicy {
+	case Block:
+		return fmt.Errorf("failed to clear cache: %w", err)
+	case Degrade:
+		c.dk.add(keys...)
+	}
+	level.Error(c.l).Log("msg", "failed to clear cache", "err", err.Error())
+
+	return nil
+}
+
+// update caches the token of a write for all keys and dependency keys in a single batch, unless they already cache a newer token
+// or the context disables caching.
+// Keys whose zedtoken could not be invalidated before the write are consistent again once the token was cached.
+// The dependency keys are deleted first, so that caches which only propagate deletions, like cache/broadcast,
+// invalidate the dependent object types on all instances. A missing dependency key is a cache miss, see lookupAll.
+// If the batch fails, the Set policy is applied to all keys, because it is unknown which of them were written.
+// With Degrade the object types are also evaluated fully consistently by this client until a following write succeeds.
+func (c *permissionClient) update(ctx context.Context, keys, deps []string, token string) error {
+	// With WithoutCache, the written keys stay invalidated. The dependency keys are written anyway,
+	// because they invalidate their object types.
+	if overrideFromContext(ctx).bypass {
+		keys = nil
+	}
+	if len(keys) == 0 && len(deps) == 0 {
+		return nil
+	}
+	var err error
+	if len(deps) != 0 {
+		err = c.ca.DelContext(ctx, deps...)
+	}
+	if err == nil {
+		err = setAll(ctx, c.ca, c.ttl, append(keys, deps...), token)
+	}
+	if err != nil {
+		if c.setPolicy == Degrade {
+			c.dk.add(deps...)
+		}
+		return c.setFailed(ctx, err, append(keys, deps...)...)
+	}
+	c.dk.remove(keys...)
+	c.dk.remove(deps...)
+
+	return nil
+}
+
+// degradedKeys holds the keys whose zedtokens could not be invalidated.
+// Requests for these keys are evaluated fully consistently.
+type degradedKeys struct {
+	mu   sync.RWMutex
+	keys map[string]struct{}
+}
+
+func newDegradedKeys() *degradedKeys {
+	return &degradedKeys{keys: make(map[string]struct{})}
+}
+
+func (dk *degradedKeys) add(keys ...string) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+
+	for _, k := range keys {
+		dk.keys[k] = struct{}{}
+	}
+}
+
+func (dk *degradedKeys) remove(keys ...string) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+
+	for _, k := range keys {
+		delete(dk.keys, k)
+	}
+}
+
+func (dk *degradedKeys) has(key string) bool {
+	dk.mu.RLock()
+	defer dk.mu.RUnlock()
+
+	_, ok := dk.keys[key]
+
+	return ok
+}
+
+// breakerCache fails operations on the wrapped cache with ErrCircuitOpen while the circuit breaker is open.
+type breakerCache struct {
+	c  cache.ContextCache
+	cb *gobreaker.CircuitBreaker
+}
+
+var (
+	_ cache.BatchCache     = &breakerCache{}
+	_ cache.ContextCache   = &breakerCache{}
+	_ cache.MonotonicCache = &breakerCache{}
+	_ cache.TTLCache       = &breakerCache{}
+)
+
+// newBreakerCache wraps the cache with a circuit breaker and records its state in the metrics.
+func newBreakerCache(c cache.ContextCache, s gobreaker.Settings, m *metrics, backend string) *breakerCache {
+	s.Name = backend
+	// Cache misses and canceled requests do not indicate that the cache is unavailable.
+	s.IsSuccessful = func(err error) bool {
+		return err == nil || errors.Is(err, cache.ErrCacheMiss) || errors.Is(err, context.Canceled)
+	}
+	s.OnStateChange = func(_ string, _, to gobreaker.State) {
+		open := 0.0
+		if to == gobreaker.StateOpen {
+			open = 1
+		}
+		m.circuitOpen.WithLabelValues(backend).Set(open)
+	}
+
+	return &breakerCache{c: c, cb: gobreaker.NewCircuitBreaker(s)}
+}
+
+func (bc *breakerCache) do(f func() error) error {
+	_, err := bc.cb.Execute(func() (interface{}, error) {
+		return nil, f()
+	})
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return ErrCircuitOpen
+	}
+
+	return err
+}
+
+func (bc *breakerCache) GetContext(ctx context.Context, key string) (string, error) {
+	var v string
+	err := bc.do(func() (err error) {
+		v, err = bc.c.GetContext(ctx, key)
+		return err
+	})
+
+	return v, err
+}
+
+func (bc *breakerCache) SetContext(ctx context.Context, key, value string) error {
+	return bc.do(func() error {
+		return bc.c.SetContext(ctx, key, value)
+	})
+}
+
+func (bc *breakerCache) DelContext(ctx context.Context, keys ...string) error {
+	return bc.do(func() error {
+		return bc.c.DelContext(ctx, keys...)
+	})
+}
+
+func (bc *breakerCache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return bc.do(func() error {
+		return cache.SetWithTTL(ctx, bc.c, key, value, ttl)
+	})
+}
+
+func (bc *breakerCache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	return bc.do(func() error {
+		return cache.SetIfNewer(ctx, bc.c, key, value, ttl, cmp)
+	})
+}
+
+func (bc *breakerCache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	var v map[string]string
+	err := bc.do(func() (err error) {
+		v, err = cache.GetMulti(ctx, bc.c, keys...)
+		return err
+	})
+
+	return v, err
+}
+
+func (bc *breakerCache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	return bc.do(func() error {
+		return cache.SetMulti(ctx, bc.c, values, ttl, cmp)
+	})
+}
@@ -0,0 +1,314 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/spicedbtest"
+)
+
+var errUnavailable = errors.New("cache unavailable")
+
+// failingCache fails the operations that are switched on and counts all operations.
+type failingCache struct {
+	*gocache.Cache
+
+	failGet, failSet, failDel atomic.Bool
+	calls                     int32
+}
+
+func newFailingCache() *failingCache {
+	return &failingCache{Cache: gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))}
+}
+
+func (c *failingCache) GetContext(ctx context.Context, key string) (string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.failGet.Load() {
+		return "", errUnavailable
+	}
+
+	return c.Cache.GetContext(ctx, key)
+}
+
+func (c *failingCache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	atomic.AddInt32(&c.calls, 1)
+	if c.failSet.Load() {
+		return errUnavailable
+	}
+
+	return c.Cache.SetIfNewer(ctx, key, value, ttl, cmp)
+}
+
+func (c *failingCache) DelContext(ctx context.Context, keys ...string) error {
+	atomic.AddInt32(&c.calls, 1)
+	if c.failDel.Load() {
+		return errUnavailable
+	}
+
+	return c.Cache.DelContext(ctx, keys...)
+}
+
+// batchCache counts the batches that are written to the cache and all lookups.
+type batchCache struct {
+	*gocache.Cache
+
+	batches int32
+	lookups int32
+}
+
+func (c *batchCache) GetContext(ctx context.Context, key string) (string, error) {
+	atomic.AddInt32(&c.lookups, 1)
+
+	return c.Cache.GetContext(ctx, key)
+}
+
+func (c *batchCache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	atomic.AddInt32(&c.batches, 1)
+
+	return cache.SetMulti(ctx, c.Cache, values, ttl, cmp)
+}
+
+func (c *batchCache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	atomic.AddInt32(&c.lookups, 1)
+
+	return cache.GetMulti(ctx, c.Cache, keys...)
+}
+
+func TestUpdateBatch(t *testing.T) {
+	ctx := context.Background()
+	s := spicedbtest.New()
+	ca := &batchCache{Cache: gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))}
+	c := NewPermissionServiceClient(s, ca)
+
+	var updates []*pb.RelationshipUpdate
+	for _, id := range []string{"1", "2", "3"} {
+		updates = append(updates, &pb.RelationshipUpdate{
+			Operation: pb.RelationshipUpdate_OPERATION_TOUCH,
+			Relationship: &pb.Relationship{
+				Resource: &pb.ObjectReference{ObjectType: "post", ObjectId: id},
+				Relation: "read",
+				Subject:  &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: id}},
+			},
+		})
+	}
+	res, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{Updates: updates})
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ca.batches))
+	for _, k := range []string{"post#1", "post#2", "post#3", "user#1", "user#2", "user#3"} {
+		v, err := ca.Get(k)
+		require.NoError(t, err)
+		assert.Equal(t, res.WrittenAt.Token, v)
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	ctx := context.Background()
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+
+	for _, opts := range [][]Option{nil, {WithSingleflight()}} {
+		s := spicedbtest.New()
+		ca := &batchCache{Cache: gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))}
+		c := NewPermissionServiceClient(s, ca, opts...)
+		res, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation: pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{
+					Resource: &pb.ObjectReference{ObjectType: "post", ObjectId: "1"},
+					Relation: "read",
+					Subject:  user1,
+				},
+			}},
+		})
+		require.NoError(t, err)
+		for _, k := range []string{"->post", "->user"} {
+			require.NoError(t, ca.Set(k, res.WrittenAt.Token))
+		}
+
+		// The subject, the resource type and their dependency keys are looked up in a single call.
+		atomic.StoreInt32(&ca.lookups, 0)
+		lr, err := c.LookupResources(ctx, &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: user1})
+		require.NoError(t, err)
+		_, err = lr.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&ca.lookups))
+		cs := s.Consistencies("LookupResources")
+		require.Len(t, cs, 1)
+		assert.Equal(t, res.WrittenAt.Token, cs[0].GetAtLeastAsFresh().GetToken())
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	post1 := &pb.ObjectReference{ObjectType: "post", ObjectId: "1"}
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+	check := func(c pb.PermissionsServiceClient) error {
+		_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Resource: post1, Permission: "read", Subject: user1})
+		return err
+	}
+	write := func(c pb.PermissionsServiceClient) error {
+		_, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: user1},
+			}},
+		})
+		return err
+	}
+
+	t.Run("get", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy Policy
+			err    bool
+			// fully reports whether the request is evaluated fully consistently. Otherwise it has no consistency.
+			fully bool
+		}{
+			{policy: Block, err: true},
+			{policy: Degrade, fully: true},
+			{policy: Proceed},
+		} {
+			t.Run(tc.policy.String(), func(t *testing.T) {
+				s := spicedbtest.New()
+				ca := newFailingCache()
+				ca.failGet.Store(true)
+				c := NewPermissionServiceClient(s, ca, WithGetPolicy(tc.policy))
+
+				// A consistency without a requirement is treated like a missing one.
+				for _, consistency := range []*pb.Consistency{nil, {}} {
+					_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Consistency: consistency, Resource: post1, Permission: "read", Subject: user1})
+					if tc.err {
+						assert.ErrorIs(t, err, errUnavailable)
+						assert.Empty(t, s.Requests())
+						continue
+					}
+					require.NoError(t, err)
+					cs := s.Consistencies("CheckPermission")
+					require.NotEmpty(t, cs)
+					last := cs[len(cs)-1]
+					assert.Equal(t, tc.fully, last.GetFullyConsistent())
+					if !tc.fully {
+						assert.Nil(t, last)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := newFailingCache()
+		ca.failSet.Store(true)
+
+		assert.NoError(t, check(NewPermissionServiceClient(s, ca)))
+		assert.ErrorIs(t, check(NewPermissionServiceClient(s, ca, WithSetPolicy(Block))), errUnavailable)
+
+		require.NoError(t, ca.Cache.Set("post#1", s.Token()))
+		require.NoError(t, check(NewPermissionServiceClient(s, ca, WithSetPolicy(Degrade))))
+		_, err := ca.Cache.Get("post#1")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+	})
+
+	t.Run("del", func(t *testing.T) {
+		t.Run("block", func(t *testing.T) {
+			s := spicedbtest.New()
+			ca := newFailingCache()
+			ca.failDel.Store(true)
+			c := NewPermissionServiceClient(s, ca)
+
+			assert.ErrorIs(t, write(c), errUnavailable)
+			assert.Empty(t, s.Requests())
+		})
+
+		t.Run("degrade", func(t *testing.T) {
+			s := spicedbtest.New()
+			ca := newFailingCache()
+			stale := s.Token()
+			require.NoError(t, ca.Cache.Set("post#1", stale))
+			require.NoError(t, ca.Cache.Set("->post", stale))
+			ca.failDel.Store(true)
+			ca.failSet.Store(true)
+			c := NewPermissionServiceClient(s, ca, WithDelPolicy(Degrade))
+
+			require.NoError(t, write(c))
+			ca.failDel.Store(false)
+			ca.failSet.Store(false)
+			// The stale zedtoken is still cached, but not used.
+			require.NoError(t, check(c))
+			cs := s.Consistencies("CheckPermission")
+			require.Len(t, cs, 1)
+			assert.True(t, cs[0].GetFullyConsistent())
+
+			// The next write replaces the stale zedtoken.
+			require.NoError(t, write(c))
+			s.ResetRequests()
+			require.NoError(t, check(c))
+			cs = s.Consistencies("CheckPermission")
+			require.Len(t, cs, 1)
+			assert.Equal(t, s.Token(), cs[0].GetAtLeastAsFresh().GetToken())
+		})
+
+		t.Run("proceed", func(t *testing.T) {
+			s := spicedbtest.New()
+			ca := newFailingCache()
+			ca.failDel.Store(true)
+			c := NewPermissionServiceClient(s, ca, WithDelPolicy(Proceed))
+
+			require.NoError(t, write(c))
+			assert.Len(t, s.Consistencies("WriteRelationships"), 1)
+		})
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	s := spicedbtest.New()
+	ca := newFailingCache()
+	ca.failGet.Store(true)
+	r := prometheus.NewRegistry()
+	c := NewPermissionServiceClient(s, ca, WithCircuitBreaker(2, 100*time.Millisecond), WithBackendName("failing"), WithRegisterer(r))
+	check := func() {
+		t.Helper()
+
+		_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{
+			Resource:   &pb.ObjectReference{ObjectType: "post", ObjectId: "1"},
+			Permission: "read",
+			Subject:    &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}},
+		})
+		require.NoError(t, err)
+	}
+
+	// The failed lookup and write of the first request open the circuit breaker.
+	ca.failSet.Store(true)
+	check()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&ca.calls))
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.(*permissionClient).m.circuitOpen.WithLabelValues("failing")))
+
+	// The cache is not called while the circuit breaker is open.
+	check()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&ca.calls))
+	for _, cs := range s.Consistencies("CheckPermission") {
+		assert.True(t, cs.GetFullyConsistent())
+	}
+
+	// The circuit breaker closes once the cache recovered.
+	ca.failGet.Store(false)
+	ca.failSet.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	check()
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.(*permissionClient).m.circuitOpen.WithLabelValues("failing")))
+	check()
+	cs := s.Consistencies("CheckPermission")
+	assert.Equal(t, s.Token(), cs[len(cs)-1].GetAtLeastAsFresh().GetToken())
+}
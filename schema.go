@@ -0,0 +1,171 @@
+package zedcache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/go-kit/log/level"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/schema"
+	"github.com/connylabs/zedcache/zedtoken"
+)
+
+// Invalidation decides how requests for object types are evaluated
+// after a write changed their permissions through relationships of other objects.
+type Invalidation int
+
+const (
+	// TokenBump evaluates requests for all objects of the dependent object types at least as fresh as the write.
+	TokenBump Invalidation = iota
+	// GenerationBump discards the cached zedtokens of all objects of the dependent object types that are older than the write,
+	// so that requests for them are evaluated fully consistently until their zedtokens are cached again.
+	GenerationBump
+)
+
+func (i Invalidation) String() string {
+	switch i {
+	case TokenBump:
+		return "token-bump"
+	case GenerationBump:
+		return "generation-bump"
+	default:
+		return fmt.Sprintf("Invalidation(%d)", int(i))
+	}
+}
+
+// schemaRefreshInterval is the interval after which the schema is read again.
+const schemaRefreshInterval = time.Minute
+
+// WithSchema reads the schema from the given SchemaServiceClient to determine which object types
+// depend on the relations that are written, e.g. with `permission read = owner + parent->read` on document,
+// a write of a folder's relationships changes the permissions of all documents in the folder.
+// Every write records its zedtoken for the dependent object types and requests for these types are evaluated
+// according to the given Invalidation.
+// If no zedtoken is recorded for an object type, e.g. because it was evicted, requests for it are evaluated fully consistently
+// and record their zedtoken instead.
+// The schema is read again every minute.
+func WithSchema(c pb.SchemaServiceClient, i Invalidation) Option {
+	return func(pc *permissionClient) {
+		pc.deps = &dependencies{c: c}
+		pc.invalidation = i
+	}
+}
+
+// WithWatchSchema reads the schema from the given SchemaServiceClient to record the zedtokens of changes
+// for the object types that depend on the changed relations, like the cached client does with WithSchema.
+func WithWatchSchema(c pb.SchemaServiceClient) WatchOption {
+	return func(w *Watcher) {
+		w.deps = &dependencies{c: c}
+	}
+}
+
+// dependencies reads and caches the schema.
+type dependencies struct {
+	c pb.SchemaServiceClient
+
+	mu     sync.Mutex
+	s      *schema.Schema
+	readAt time.Time
+}
+
+// schema returns the schema and reads it again if it is older than the refresh interval.
+func (d *dependencies) schema(ctx context.Context) (*schema.Schema, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.s != nil && time.Since(d.readAt) < schemaRefreshInterval {
+		return d.s, nil
+	}
+	res, err := d.c.ReadSchema(ctx, &pb.ReadSchemaRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	s, err := schema.Parse(res.SchemaText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	d.s, d.readAt = s, time.Now()
+
+	return s, nil
+}
+
+// keys returns the dependency keys of the object types that depend on the given relations.
+// A relation with an empty name stands for all relations of the object type.
+func (d *dependencies) keys(ctx context.Context, kf KeyFunc, relations map[[2]string]struct{}) ([]string, error) {
+	if d == nil || len(relations) == 0 {
+		return nil, nil
+	}
+	s, err := d.schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]struct{})
+	for r := range relations {
+		for _, t := range s.Dependents(r[0], r[1]) {
+			set[kf(dependencyKey(t))] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// updateRelations returns the resource types and relations of the given updates.
+func updateRelations(updates []*pb.RelationshipUpdate) map[[2]string]struct{} {
+	relations := make(map[[2]string]struct{})
+	for _, u := range updates {
+		relations[[2]string{u.Relationship.Resource.ObjectType, u.Relationship.Relation}] = struct{}{}
+	}
+
+	return relations
+}
+
+// dependencyKeys returns the dependency keys of the object types that depend on the given relations.
+// If the schema can not be read, the error is handled according to the Del policy,
+// because the dependent object types can not be invalidated.
+func (c *permissionClient) dependencyKeys(ctx context.Context, relations map[[2]string]struct{}) ([]string, error) {
+	keys, err := c.deps.keys(ctx, c.keyFunc(ctx), relations)
+	if err == nil {
+		return keys, nil
+	}
+	if c.delPolicy == Block {
+		return nil, err
+	}
+	level.Error(c.l).Log("msg", "failed to determine dependent object types", "err", err.Error())
+
+	return nil, nil
+}
+
+// dependencyLookups returns the dependency keys of the object types of the given keys, by object type.
+// They are returned for every object type, not only for those that depend on other objects,
+// because deletions by a filter without a resource id also advance the dependency key of the resource type, see DeleteRelationships.
+func dependencyLookups(kf KeyFunc, keys []Key) map[string]string {
+	deps := make(map[string]string, len(keys))
+	for _, k := range keys {
+		deps[k.ObjectType] = kf(dependencyKey(k.ObjectType))
+	}
+
+	return deps
+}
+
+// applyDependency applies the zedtoken d of the last write that changed the permissions of an object type
+// through other objects or deleted relationships of any of its objects to the cached zedtoken t of a key of that type according to the Invalidation.
+func (c *permissionClient) applyDependency(t, d string) (string, error) {
+	if !cache.IsNewer(d, t, zedtoken.Compare) {
+		return t, nil
+	}
+	if c.invalidation == GenerationBump {
+		return "", cache.ErrCacheMiss
+	}
+
+	return d, nil
+}
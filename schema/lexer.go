@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenPunct
+	// tokenOther is any other token, e.g. a string or a number in a caveat expression.
+	tokenOther
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || r == '/' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// skip skips whitespace and comments.
+func (l *lexer) skip() {
+	for l.pos < len(l.src) {
+		rest := l.src[l.pos:]
+		switch {
+		case rest[0] == '\n':
+			l.line++
+			l.pos++
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r' || rest[0] == ';':
+			l.pos++
+		case strings.HasPrefix(rest, "//"):
+			i := strings.IndexByte(rest, '\n')
+			if i < 0 {
+				i = len(rest)
+			}
+			l.pos += i
+		case strings.HasPrefix(rest, "/*"):
+			i := strings.Index(rest[2:], "*/")
+			if i < 0 {
+				i = len(rest)
+			} else {
+				i += 4
+			}
+			l.line += strings.Count(rest[:i], "\n")
+			l.pos += i
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() token {
+	l.skip()
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, line: l.line + 1}
+	}
+	start := l.pos
+	t := token{line: l.line + 1}
+	rest := l.src[l.pos:]
+	r, size := utf8.DecodeRuneInString(rest)
+	switch {
+	case isIdent(r):
+		for l.pos < len(l.src) {
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if !isIdent(r) {
+				break
+			}
+			l.pos += size
+		}
+		t.kind = tokenIdent
+	case strings.HasPrefix(rest, "->"):
+		l.pos += 2
+		t.kind = tokenPunct
+	case strings.ContainsRune("{}()|:#*=+-&.,", r):
+		l.pos += size
+		t.kind = tokenPunct
+	case r == '"' || r == '\'' || r == '`':
+		l.pos += size
+		for l.pos < len(l.src) {
+			c, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			l.pos += size
+			if c == '\\' && l.pos < len(l.src) {
+				l.pos++
+				continue
+			}
+			if c == r {
+				break
+			}
+		}
+		t.kind = tokenOther
+	default:
+		l.pos += size
+		t.kind = tokenOther
+	}
+	t.text = l.src[start:l.pos]
+
+	return t
+}
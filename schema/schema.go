@@ -0,0 +1,403 @@
+// Package schema parses SpiceDB schemas and determines which object types depend on the relations of other objects.
+//
+// The parser only extracts the relations, their allowed subject types and the relations and arrows that permissions refer to.
+// Caveats are skipped and the schema is not validated beyond what is needed to parse it.
+package schema
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SubjectType is an allowed subject type of a relation, e.g. `group#member` or `user:*`.
+type SubjectType struct {
+	ObjectType string
+	// Relation is the relation of a subject set, e.g. member for `group#member`.
+	Relation string
+	Wildcard bool
+}
+
+// reference is a relation or permission of the same object, or an arrow over the relation name if arrow is not empty.
+type reference struct {
+	name  string
+	arrow string
+}
+
+type definition struct {
+	relations   map[string][]SubjectType
+	permissions map[string][]reference
+}
+
+// Schema is a parsed SpiceDB schema.
+type Schema struct {
+	defs map[string]*definition
+}
+
+// node is a relation or permission of an object type.
+type node struct {
+	objectType string
+	name       string
+}
+
+// Relations returns the allowed subject types of the relation of the object type.
+func (s *Schema) Relations(objectType, relation string) []SubjectType {
+	d, ok := s.defs[objectType]
+	if !ok {
+		return nil
+	}
+
+	return d.relations[relation]
+}
+
+// Depends reports whether the permissions or relations of the object type depend on relationships of other objects,
+// i.e. whether it has an arrow or a relation that allows subject sets.
+func (s *Schema) Depends(objectType string) bool {
+	d, ok := s.defs[objectType]
+	if !ok {
+		return false
+	}
+	for _, ts := range d.relations {
+		for _, t := range ts {
+			if t.Relation != "" {
+				return true
+			}
+		}
+	}
+	for _, refs := range d.permissions {
+		for _, r := range refs {
+			if r.arrow != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Dependents returns the sorted object types whose permissions or relations depend on the relation of the given object type
+// through relationships of other objects, i.e. through arrows or subject sets.
+// E.g. with `permission read = parent->read` on document, a write of folder#viewer affects the documents in the folder.
+// Permissions of the written object itself are not considered dependent, unless they are also reachable through other objects,
+// e.g. with `permission read = viewer + parent->read` on folder.
+// If the relation is empty, all relations of the object type are considered.
+func (s *Schema) Dependents(objectType, relation string) []string {
+	d, ok := s.defs[objectType]
+	if !ok {
+		return nil
+	}
+	// same holds the nodes of the written object, other the nodes of other objects that depend on the relation.
+	same := make(map[node]bool)
+	other := make(map[node]bool)
+	if relation == "" {
+		for r := range d.relations {
+			same[node{objectType, r}] = true
+		}
+	} else {
+		same[node{objectType, relation}] = true
+	}
+	affected := func(n node) bool {
+		return same[n] || other[n]
+	}
+
+	for changed := true; changed; {
+		changed = false
+		mark := func(set map[node]bool, n node) {
+			if !set[n] && !other[n] {
+				set[n] = true
+				changed = true
+			}
+		}
+		for t, d := range s.defs {
+			for r, sts := range d.relations {
+				for _, st := range sts {
+					if st.Relation != "" && affected(node{st.ObjectType, st.Relation}) {
+						mark(other, node{t, r})
+					}
+				}
+			}
+			for p, refs := range d.permissions {
+				n := node{t, p}
+				for _, ref := range refs {
+					switch {
+					case other[node{t, ref.name}]:
+						mark(other, n)
+					case same[node{t, ref.name}]:
+						mark(same, n)
+					}
+					if ref.arrow == "" {
+						continue
+					}
+					for _, st := range d.relations[ref.name] {
+						if affected(node{st.ObjectType, ref.arrow}) {
+							mark(other, n)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(other) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{})
+	for n := range other {
+		set[n.objectType] = struct{}{}
+	}
+	types := make([]string, 0, len(set))
+	for t := range set {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// Parse parses the text of a SpiceDB schema.
+func Parse(text string) (*Schema, error) {
+	p := &parser{l: &lexer{src: text}}
+	p.next()
+	s := &Schema{defs: make(map[string]*definition)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.is("definition"):
+			p.next()
+			name, d, err := p.definition()
+			if err != nil {
+				return nil, err
+			}
+			s.defs[name] = d
+		case p.is("caveat"):
+			if err := p.skipBlock(); err != nil {
+				return nil, err
+			}
+		case p.is("use"):
+			p.next()
+			if _, err := p.ident(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.errorf("expected definition or caveat")
+		}
+	}
+
+	return s, nil
+}
+
+type parser struct {
+	l   *lexer
+	tok token
+}
+
+func (p *parser) next() {
+	p.tok = p.l.next()
+}
+
+func (p *parser) is(keyword string) bool {
+	return p.tok.kind == tokenIdent && p.tok.text == keyword
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s, found %q", p.tok.line, fmt.Sprintf(format, args...), p.tok.text)
+}
+
+func (p *parser) ident() (string, error) {
+	if p.tok.kind != tokenIdent {
+		return "", p.errorf("expected identifier")
+	}
+	text := p.tok.text
+	p.next()
+
+	return text, nil
+}
+
+func (p *parser) expect(text string) error {
+	if p.tok.kind != tokenPunct || p.tok.text != text {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+
+	return nil
+}
+
+func (p *parser) accept(text string) bool {
+	if p.tok.kind != tokenPunct || p.tok.text != text {
+		return false
+	}
+	p.next()
+
+	return true
+}
+
+// skipBlock skips everything up to and including the first balanced block in braces.
+func (p *parser) skipBlock() error {
+	for p.tok.kind != tokenEOF && !(p.tok.kind == tokenPunct && p.tok.text == "{") {
+		p.next()
+	}
+	depth := 0
+	for {
+		switch {
+		case p.tok.kind == tokenEOF:
+			return p.errorf("unexpected end of schema")
+		case p.tok.kind == tokenPunct && p.tok.text == "{":
+			depth++
+		case p.tok.kind == tokenPunct && p.tok.text == "}":
+			depth--
+		}
+		p.next()
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (p *parser) definition() (string, *definition, error) {
+	name, err := p.ident()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return "", nil, err
+	}
+	d := &definition{
+		relations:   make(map[string][]SubjectType),
+		permissions: make(map[string][]reference),
+	}
+	for !p.accept("}") {
+		switch {
+		case p.is("relation"):
+			p.next()
+			if err := p.relation(d); err != nil {
+				return "", nil, err
+			}
+		case p.is("permission"):
+			p.next()
+			if err := p.permission(d); err != nil {
+				return "", nil, err
+			}
+		default:
+			return "", nil, p.errorf("expected relation or permission in definition %q", name)
+		}
+	}
+
+	return name, d, nil
+}
+
+func (p *parser) relation(d *definition) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	for {
+		var st SubjectType
+		if st.ObjectType, err = p.ident(); err != nil {
+			return err
+		}
+		switch {
+		case p.accept(":"):
+			if err := p.expect("*"); err != nil {
+				return err
+			}
+			st.Wildcard = true
+		case p.accept("#"):
+			if st.Relation, err = p.ident(); err != nil {
+				return err
+			}
+		}
+		// Caveats and expirations do not change which objects the relation refers to.
+		if p.is("with") {
+			p.next()
+			if _, err := p.ident(); err != nil {
+				return err
+			}
+			for p.is("and") {
+				p.next()
+				if _, err := p.ident(); err != nil {
+					return err
+				}
+			}
+		}
+		d.relations[name] = append(d.relations[name], st)
+		if !p.accept("|") {
+			return nil
+		}
+	}
+}
+
+func (p *parser) permission(d *definition) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	refs, err := p.expression()
+	if err != nil {
+		return err
+	}
+	d.permissions[name] = refs
+
+	return nil
+}
+
+// expression parses a permission expression and returns all references in it.
+// Operators do not matter for dependencies, so they are only skipped.
+func (p *parser) expression() ([]reference, error) {
+	var refs []reference
+	for {
+		rs, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, rs...)
+		if !p.accept("+") && !p.accept("-") && !p.accept("&") {
+			return refs, nil
+		}
+	}
+}
+
+func (p *parser) term() ([]reference, error) {
+	if p.accept("(") {
+		refs, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+
+		return refs, p.expect(")")
+	}
+	if p.is("nil") {
+		p.next()
+		return nil, nil
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	ref := reference{name: name}
+	switch {
+	case p.accept("->"):
+		if ref.arrow, err = p.ident(); err != nil {
+			return nil, err
+		}
+	case p.accept("."):
+		// Arrow functions, e.g. parent.any(read).
+		if _, err := p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if ref.arrow, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	return []reference{ref}, nil
+}
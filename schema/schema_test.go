@@ -0,0 +1,105 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `
+use expiration
+
+/** user is a user. */
+definition user {}
+
+caveat ip_allowlist(user_ip ipaddress, cidr string) {
+	user_ip.in_cidr(cidr) && cidr != "{"
+}
+
+definition group {
+	relation member: user | group#member
+}
+
+// folder is a folder.
+definition folder {
+	relation parent: folder
+	relation viewer: user | user:* | group#member with ip_allowlist
+	relation banned: user with expiration
+
+	permission read = (viewer - banned) + parent->read
+}
+
+definition tenant/document {
+	relation folder: folder
+	relation owner: user with ip_allowlist and expiration
+	relation blocked: nil_user
+
+	permission edit = owner
+	permission read = edit + folder.any(read) & nil
+}
+
+definition nil_user {}
+
+definition audit {
+	relation document: tenant/document
+	permission view = document->edit
+}
+`
+
+func TestParse(t *testing.T) {
+	s, err := Parse(testSchema)
+	require.NoError(t, err)
+
+	assert.Equal(t, []SubjectType{
+		{ObjectType: "user"},
+		{ObjectType: "user", Wildcard: true},
+		{ObjectType: "group", Relation: "member"},
+	}, s.Relations("folder", "viewer"))
+	assert.Equal(t, []SubjectType{{ObjectType: "user"}}, s.Relations("tenant/document", "owner"))
+	assert.Nil(t, s.Relations("missing", "viewer"))
+
+	assert.True(t, s.Depends("group"))
+	assert.True(t, s.Depends("folder"))
+	assert.True(t, s.Depends("tenant/document"))
+	assert.False(t, s.Depends("user"))
+	assert.False(t, s.Depends("missing"))
+
+	for _, text := range []string{
+		"definition {}",
+		"definition user { relation owner user }",
+		"definition user { permission read = (owner }",
+		"definition user {",
+		"relation owner: user",
+		"caveat c(a int) { a > 1",
+	} {
+		_, err := Parse(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	s, err := Parse(testSchema)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		objectType string
+		relation   string
+		dependents []string
+	}{
+		// Every folder below the folder, every document in them and audits of the documents depend on the viewers of a folder.
+		{objectType: "folder", relation: "viewer", dependents: []string{"folder", "tenant/document"}},
+		{objectType: "folder", relation: "parent", dependents: []string{"folder", "tenant/document"}},
+		// Subject sets of groups make group members a dependency of groups and folders.
+		{objectType: "group", relation: "member", dependents: []string{"folder", "group", "tenant/document"}},
+		// Audits only depend on the edit permission of documents.
+		{objectType: "tenant/document", relation: "owner", dependents: []string{"audit"}},
+		{objectType: "tenant/document", relation: "folder"},
+		{objectType: "tenant/document", dependents: []string{"audit"}},
+		{objectType: "audit", relation: "document"},
+		{objectType: "user", relation: "anything"},
+		{objectType: "missing", relation: "viewer"},
+	} {
+		assert.Equal(t, tc.dependents, s.Dependents(tc.objectType, tc.relation), "%s#%s", tc.objectType, tc.relation)
+	}
+}
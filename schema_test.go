@@ -0,0 +1,216 @@
+package zedcache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/spicedbtest"
+)
+
+const testSchema = `
+definition user {}
+
+definition folder {
+	relation viewer: user
+	permission read = viewer
+}
+
+definition document {
+	relation parent: folder
+	relation owner: user
+	permission read = owner + parent->read
+}
+`
+
+func TestSchema(t *testing.T) {
+	ctx := context.Background()
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+	folder1 := &pb.ObjectReference{ObjectType: "folder", ObjectId: "1"}
+	document1 := &pb.ObjectReference{ObjectType: "document", ObjectId: "1"}
+	newServer := func(t *testing.T) *spicedbtest.Server {
+		t.Helper()
+
+		s := spicedbtest.New()
+		_, err := s.WriteSchema(ctx, &pb.WriteSchemaRequest{Schema: testSchema})
+		require.NoError(t, err)
+
+		return s
+	}
+	check := func(t *testing.T, c pb.PermissionsServiceClient, resource *pb.ObjectReference) {
+		t.Helper()
+
+		_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Resource: resource, Permission: "read", Subject: user1})
+		require.NoError(t, err)
+	}
+	write := func(c pb.PermissionsServiceClient, resource *pb.ObjectReference, relation string) (string, error) {
+		res, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{Resource: resource, Relation: relation, Subject: user1},
+			}},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return res.WrittenAt.Token, nil
+	}
+	lastConsistency := func(t *testing.T, s *spicedbtest.Server) *pb.Consistency {
+		t.Helper()
+
+		cs := s.Consistencies("CheckPermission")
+		require.NotEmpty(t, cs)
+
+		return cs[len(cs)-1]
+	}
+
+	t.Run("token bump", func(t *testing.T) {
+		s := newServer(t)
+		c := NewPermissionServiceClient(s, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithSchema(s, TokenBump))
+
+		check(t, c, document1)
+		token, err := write(c, folder1, "viewer")
+		require.NoError(t, err)
+		check(t, c, document1)
+		assert.Equal(t, token, lastConsistency(t, s).GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("generation bump", func(t *testing.T) {
+		s := newServer(t)
+		c := NewPermissionServiceClient(s, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithSchema(s, GenerationBump))
+
+		check(t, c, document1)
+		_, err := write(c, folder1, "viewer")
+		require.NoError(t, err)
+		check(t, c, document1)
+		assert.True(t, lastConsistency(t, s).GetFullyConsistent())
+
+		// The zedtoken is cached again.
+		check(t, c, document1)
+		assert.Equal(t, s.Token(), lastConsistency(t, s).GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("single lookup", func(t *testing.T) {
+		s := newServer(t)
+		ca := &batchCache{Cache: gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))}
+		c := NewPermissionServiceClient(s, ca, WithSchema(s, TokenBump))
+
+		check(t, c, document1)
+		token, err := write(c, folder1, "viewer")
+		require.NoError(t, err)
+		// The document and the dependency key of documents are looked up in a single call.
+		atomic.StoreInt32(&ca.lookups, 0)
+		check(t, c, document1)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&ca.lookups))
+		assert.Equal(t, token, lastConsistency(t, s).GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("evicted dependency key", func(t *testing.T) {
+		for _, invalidation := range []Invalidation{TokenBump, GenerationBump} {
+			s := newServer(t)
+			ca := gcache.New(gcache.NoExpiration, gcache.NoExpiration)
+			c := NewPermissionServiceClient(s, gocache.New(ca), WithSchema(s, invalidation))
+
+			check(t, c, document1)
+			_, err := write(c, folder1, "viewer")
+			require.NoError(t, err)
+			// A missing dependency key could have been evicted after a write, so the document is not served from the cache.
+			ca.Delete("->document")
+			check(t, c, document1)
+			assert.True(t, lastConsistency(t, s).GetFullyConsistent())
+
+			// The dependency key is cached again with the zedtoken of the fully consistent request.
+			assert.Equal(t, s.Token(), getCacheValue(t, ca, "->document"))
+			check(t, c, document1)
+			assert.Equal(t, s.Token(), lastConsistency(t, s).GetAtLeastAsFresh().GetToken())
+		}
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		s := newServer(t)
+		caches := newBroadcastCaches(t, 2)
+		a := NewPermissionServiceClient(s, caches[0], WithSchema(s, TokenBump))
+		b := NewPermissionServiceClient(s, caches[1], WithSchema(s, TokenBump))
+
+		check(t, b, document1)
+		check(t, b, document1)
+		assert.NotNil(t, lastConsistency(t, s).GetAtLeastAsFresh())
+		// The write of instance a removes the dependency key of documents from the local cache of instance b.
+		_, err := write(a, folder1, "viewer")
+		require.NoError(t, err)
+		check(t, b, document1)
+		assert.True(t, lastConsistency(t, s).GetFullyConsistent())
+	})
+
+	t.Run("independent object type", func(t *testing.T) {
+		s := newServer(t)
+		c := NewPermissionServiceClient(s, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithSchema(s, GenerationBump))
+
+		check(t, c, folder1)
+		cached := s.Token()
+		_, err := write(c, document1, "owner")
+		require.NoError(t, err)
+		check(t, c, folder1)
+		assert.Equal(t, cached, lastConsistency(t, s).GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("delete relationships", func(t *testing.T) {
+		s := newServer(t)
+		c := NewPermissionServiceClient(s, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithSchema(s, TokenBump))
+
+		_, err := write(c, folder1, "viewer")
+		require.NoError(t, err)
+		check(t, c, document1)
+		res, err := c.DeleteRelationships(ctx, &pb.DeleteRelationshipsRequest{RelationshipFilter: &pb.RelationshipFilter{ResourceType: "folder"}})
+		require.NoError(t, err)
+		check(t, c, document1)
+		assert.Equal(t, res.DeletedAt.Token, lastConsistency(t, s).GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("schema unavailable", func(t *testing.T) {
+		s := spicedbtest.New()
+		c := NewPermissionServiceClient(s, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithSchema(s, TokenBump))
+		_, err := write(c, folder1, "viewer")
+		assert.ErrorContains(t, err, "failed to read schema")
+		assert.Empty(t, s.Consistencies("WriteRelationships"))
+
+		c = NewPermissionServiceClient(s, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithSchema(s, TokenBump), WithDelPolicy(Proceed))
+		_, err = write(c, folder1, "viewer")
+		require.NoError(t, err)
+	})
+
+	t.Run("watcher", func(t *testing.T) {
+		s := newServer(t)
+		ca := gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))
+		w := NewWatcher(s, ca, WithBackoff(time.Millisecond, time.Millisecond), WithWatchSchema(s))
+
+		wctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- w.Run(wctx)
+		}()
+		// Wait until the Watcher consumed a first change, so that it is subscribed.
+		require.Eventually(t, func() bool {
+			if _, err := write(s, folder1, "init"); err != nil {
+				return false
+			}
+			return w.Cursor() != nil
+		}, time.Second, 10*time.Millisecond)
+		token, err := write(s, folder1, "viewer")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			v, err := ca.Get(DefaultKeyFunc(dependencyKey("document")))
+			return err == nil && v == token
+		}, time.Second, time.Millisecond)
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
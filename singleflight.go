@@ -0,0 +1,146 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+// WithSingleflight coalesces concurrent requests for the same cache key.
+// Concurrent lookups of the same keys share a single call to the cache.
+// If a zedtoken is not cached, only the first CheckPermission or ExpandPermissionTree request
+// is sent to SpiceDB fully consistently; concurrent requests for the same key wait for it
+// and are then evaluated at least as fresh as the zedtoken it cached.
+// This avoids a thundering herd of fully consistent requests after deployments or invalidations.
+func WithSingleflight() Option {
+	return func(pc *permissionClient) {
+		pc.co = &coalescer{fills: make(map[string]chan struct{})}
+	}
+}
+
+// coalescer coalesces cache lookups and the requests that fill the cache after a miss.
+type coalescer struct {
+	g singleflight.Group
+
+	mu sync.Mutex
+	// gen is incremented whenever keys are forgotten, so that batch lookups that started before are not shared anymore.
+	gen uint64
+	// fills holds a channel for every key whose zedtoken is currently being fetched from SpiceDB.
+	// The channel is closed once the request is done.
+	fills map[string]chan struct{}
+}
+
+// getMulti looks up the keys in the cache with a single call.
+// Concurrent lookups of the same keys share the result of the first one.
+func (co *coalescer) getMulti(ctx context.Context, ca cache.ContextCache, keys []string) (map[string]string, error) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	co.mu.Lock()
+	group := strconv.FormatUint(co.gen, 10) + "\x00" + strings.Join(sorted, "\x00")
+	co.mu.Unlock()
+
+	ch := co.g.DoChan(group, func() (interface{}, error) {
+		return cache.GetMulti(ctx, ca, keys...)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		err := res.Err
+		if res.Shared && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			// The context of the caller that did the lookup is done, but ours is not.
+			return cache.GetMulti(ctx, ca, keys...)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return res.Val.(map[string]string), nil
+	}
+}
+
+// forget makes sure that lookups and fills that started before the keys were invalidated are not shared anymore.
+func (co *coalescer) forget(keys ...string) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	co.gen++
+	for _, k := range keys {
+		delete(co.fills, k)
+	}
+}
+
+// fill registers a request that fetches the zedtoken for the key from SpiceDB.
+// If another request is already doing so, the returned channel is closed once it is done.
+// Otherwise the channel is nil and release must be called when the zedtoken was cached.
+func (co *coalescer) fill(key string) (wait <-chan struct{}, release func()) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	if ch, ok := co.fills[key]; ok {
+		return ch, func() {}
+	}
+	ch := make(chan struct{})
+	co.fills[key] = ch
+
+	return nil, func() {
+		co.mu.Lock()
+		defer co.mu.Unlock()
+
+		if co.fills[key] == ch {
+			delete(co.fills, key)
+		}
+		close(ch)
+	}
+}
+
+// lookupMulti looks up the zedtokens for the keys in the cache with a single call.
+// The returned map only contains the keys that are cached.
+func (c *permissionClient) lookupMulti(ctx context.Context, keys []string) (map[string]string, error) {
+	if c.co == nil {
+		return cache.GetMulti(ctx, c.ca, keys...)
+	}
+
+	return c.co.getMulti(ctx, c.ca, keys)
+}
+
+// forget stops sharing lookups and fills of the given keys.
+func (c *permissionClient) forget(keys ...string) {
+	if c.co != nil {
+		c.co.forget(keys...)
+	}
+}
+
+// coalescedConsistency sets the consistency requirement and returns the missing dependency keys like consistency.
+// If the zedtoken is not cached and another request is already fetching it from SpiceDB, it waits for that request
+// and looks up the zedtoken again. Otherwise the returned function must be called once the zedtoken was cached.
+func (c *permissionClient) coalescedConsistency(ctx context.Context, method string, consistency **pb.Consistency, key Key) (bool, []Key, func(), error) {
+	callerSet := *consistency != nil && (*consistency).Requirement != nil || overrideFromContext(ctx).overridden()
+	fromCache, seeds, err := c.consistency(ctx, method, consistency, key)
+	if err != nil || c.co == nil || callerSet || fromCache {
+		return fromCache, seeds, func() {}, err
+	}
+
+	wait, release := c.co.fill(c.keyFunc(ctx)(key))
+	if wait == nil {
+		return false, seeds, release, nil
+	}
+	select {
+	case <-ctx.Done():
+		return false, seeds, release, nil
+	case <-wait:
+	}
+	c.m.coalesced.WithLabelValues(method).Inc()
+	*consistency = nil
+	fromCache, seeds, err = c.consistency(ctx, method, consistency, key)
+
+	return fromCache, seeds, release, err
+}
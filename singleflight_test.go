@@ -0,0 +1,141 @@
+package zedcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/spicedbtest"
+)
+
+// gatedClient blocks fully consistent CheckPermission requests until the gate is closed.
+type gatedClient struct {
+	pb.PermissionsServiceClient
+
+	gate chan struct{}
+}
+
+func (c *gatedClient) CheckPermission(ctx context.Context, in *pb.CheckPermissionRequest, opts ...grpc.CallOption) (*pb.CheckPermissionResponse, error) {
+	if in.Consistency.GetFullyConsistent() {
+		<-c.gate
+	}
+
+	return c.PermissionsServiceClient.CheckPermission(ctx, in, opts...)
+}
+
+// gatedCache blocks lookups until the gate is closed and counts them.
+type gatedCache struct {
+	*gocache.Cache
+
+	gate chan struct{}
+	gets int32
+}
+
+func (c *gatedCache) GetContext(ctx context.Context, key string) (string, error) {
+	atomic.AddInt32(&c.gets, 1)
+	<-c.gate
+
+	return c.Cache.GetContext(ctx, key)
+}
+
+func (c *gatedCache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	atomic.AddInt32(&c.gets, 1)
+	<-c.gate
+
+	return cache.GetMulti(ctx, c.Cache, keys...)
+}
+
+func (c *gatedCache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	return cache.SetMulti(ctx, c.Cache, values, ttl, cmp)
+}
+
+func TestSingleflight(t *testing.T) {
+	const n = 20
+	checkAll := func(t *testing.T, c pb.PermissionsServiceClient) {
+		t.Helper()
+
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := c.CheckPermission(context.Background(), &pb.CheckPermissionRequest{
+					Permission: "read",
+					Resource:   &pb.ObjectReference{ObjectType: "post", ObjectId: "1"},
+					Subject:    &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}},
+				})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	t.Run("fill", func(t *testing.T) {
+		s := spicedbtest.New()
+		gc := &gatedClient{PermissionsServiceClient: s, gate: make(chan struct{})}
+		r := prometheus.NewRegistry()
+		c := NewPermissionServiceClient(gc, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithSingleflight(), WithRegisterer(r))
+
+		time.AfterFunc(100*time.Millisecond, func() { close(gc.gate) })
+		checkAll(t, c)
+
+		var fully, fresh int
+		for _, cs := range s.Consistencies("CheckPermission") {
+			switch {
+			case cs.GetFullyConsistent():
+				fully++
+			case cs.GetAtLeastAsFresh().GetToken() == s.Token():
+				fresh++
+			}
+		}
+		assert.Equal(t, 1, fully)
+		assert.Equal(t, n-1, fresh)
+		assert.Equal(t, float64(n-1), testutil.ToFloat64(c.(*permissionClient).m.coalesced.WithLabelValues("CheckPermission")))
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := &gatedCache{Cache: gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), gate: make(chan struct{})}
+		require.NoError(t, ca.Cache.Set("post#1", s.Token()))
+		require.NoError(t, ca.Cache.Set("->post", s.Token()))
+		c := NewPermissionServiceClient(s, ca, WithSingleflight())
+
+		time.AfterFunc(100*time.Millisecond, func() { close(ca.gate) })
+		checkAll(t, c)
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&ca.gets))
+		for _, cs := range s.Consistencies("CheckPermission") {
+			assert.Equal(t, s.Token(), cs.GetAtLeastAsFresh().GetToken())
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := spicedbtest.New()
+		gc := &gatedClient{PermissionsServiceClient: s, gate: make(chan struct{})}
+		c := NewPermissionServiceClient(gc, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)))
+
+		time.AfterFunc(100*time.Millisecond, func() { close(gc.gate) })
+		checkAll(t, c)
+
+		var fully int
+		for _, cs := range s.Consistencies("CheckPermission") {
+			if cs.GetFullyConsistent() {
+				fully++
+			}
+		}
+		assert.Equal(t, n, fully)
+	})
+}
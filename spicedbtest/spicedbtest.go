@@ -0,0 +1,576 @@
+// Package spicedbtest provides an in-memory stand-in for SpiceDB's PermissionsService, SchemaService and WatchService.
+//
+// The fake stores relationships in memory and evaluates permissions as direct relations,
+// i.e. a subject has a permission on a resource if a relationship with the permission as relation exists.
+// Schemas are stored and returned as they are written, but they are neither validated nor evaluated.
+// Every write creates a new revision and the fake issues monotonically increasing zedtokens for them.
+// It records the consistency requirement of every request,
+// so that tests can assert which requests were evaluated with a cached zedtoken.
+package spicedbtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/authzed/authzed-go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/connylabs/zedcache/zedtoken"
+)
+
+// Request is a request that was received by the fake.
+type Request struct {
+	// Method is the name of the RPC, e.g. "CheckPermission".
+	Method string
+	// Consistency is the consistency requirement of the request.
+	// It is nil for requests without a consistency requirement, e.g. WriteRelationships.
+	Consistency *pb.Consistency
+}
+
+// Server is an in-memory fake of SpiceDB.
+// It implements pb.PermissionsServiceClient, pb.SchemaServiceClient and pb.WatchServiceClient.
+type Server struct {
+	mu            sync.Mutex
+	revision      uint64
+	gcRevision    uint64
+	relationships map[string]*pb.Relationship
+	schema        string
+	changes       []change
+	requests      []Request
+	// changed is closed and replaced whenever a new revision is written.
+	changed chan struct{}
+}
+
+// change is the set of updates that was written at a revision.
+type change struct {
+	revision uint64
+	updates  []*pb.RelationshipUpdate
+}
+
+var (
+	_ pb.PermissionsServiceClient = &Server{}
+	_ pb.SchemaServiceClient      = &Server{}
+	_ pb.WatchServiceClient       = &Server{}
+)
+
+// New creates an empty fake at revision 1.
+func New() *Server {
+	return &Server{
+		revision:      1,
+		relationships: make(map[string]*pb.Relationship),
+		changed:       make(chan struct{}),
+	}
+}
+
+// Client returns an authzed.Client that uses the fake for the PermissionsService, the SchemaService and the WatchService.
+func (s *Server) Client() *authzed.Client {
+	return &authzed.Client{
+		PermissionsServiceClient: s,
+		SchemaServiceClient:      s,
+		WatchServiceClient:       s,
+	}
+}
+
+// Token returns the zedtoken of the current revision.
+func (s *Server) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token()
+}
+
+func (s *Server) token() string {
+	return zedtoken.New(strconv.FormatUint(s.revision, 10))
+}
+
+// GarbageCollect rejects zedtokens of all revisions older than the current one,
+// like SpiceDB does for revisions that are older than its GC window.
+func (s *Server) GarbageCollect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gcRevision = s.revision
+}
+
+// Requests returns all requests that were received since the fake was created or last reset.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// Consistencies returns the consistency requirements of all requests of the given method.
+func (s *Server) Consistencies(method string) []*pb.Consistency {
+	var cs []*pb.Consistency
+	for _, r := range s.Requests() {
+		if r.Method == method {
+			cs = append(cs, r.Consistency)
+		}
+	}
+
+	return cs
+}
+
+// ResetRequests forgets all recorded requests.
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = nil
+}
+
+// record records a request and checks its consistency requirement.
+// It must be called with the lock held.
+func (s *Server) record(method string, c *pb.Consistency) error {
+	if c != nil {
+		c = proto.Clone(c).(*pb.Consistency)
+	}
+	s.requests = append(s.requests, Request{Method: method, Consistency: c})
+
+	var t *pb.ZedToken
+	switch r := c.GetRequirement().(type) {
+	case *pb.Consistency_AtLeastAsFresh:
+		t = r.AtLeastAsFresh
+	case *pb.Consistency_AtExactSnapshot:
+		t = r.AtExactSnapshot
+	default:
+		return nil
+	}
+	rev, err := zedtoken.Decode(t.GetToken())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to decode zedtoken: %v", err)
+	}
+	revision, err := strconv.ParseUint(rev, 10, 64)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to decode zedtoken: %v", err)
+	}
+	if revision > s.revision {
+		return status.Errorf(codes.FailedPrecondition, "invalid revision requested: %d is in the future", revision)
+	}
+	if revision < s.gcRevision {
+		return status.Error(codes.OutOfRange, "invalid zedtoken: revision has expired")
+	}
+
+	return nil
+}
+
+// commit writes a new revision with the given updates.
+// It must be called with the lock held.
+func (s *Server) commit(updates []*pb.RelationshipUpdate) {
+	s.revision++
+	s.changes = append(s.changes, change{revision: s.revision, updates: updates})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// sorted returns all relationships that match the filter in a stable order.
+// It must be called with the lock held.
+func (s *Server) sorted(match func(*pb.Relationship) bool) []*pb.Relationship {
+	keys := make([]string, 0, len(s.relationships))
+	for k, r := range s.relationships {
+		if match(r) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	rs := make([]*pb.Relationship, 0, len(keys))
+	for _, k := range keys {
+		rs = append(rs, s.relationships[k])
+	}
+
+	return rs
+}
+
+func (s *Server) ReadRelationships(ctx context.Context, in *pb.ReadRelationshipsRequest, _ ...grpc.CallOption) (pb.PermissionsService_ReadRelationshipsClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &readRelationshipsStream{clientStream: clientStream{ctx: ctx}}
+	if st.err = s.record("ReadRelationships", in.Consistency); st.err != nil {
+		return st, nil
+	}
+	for _, r := range s.sorted(func(r *pb.Relationship) bool { return matches(in.RelationshipFilter, r) }) {
+		st.items = append(st.items, &pb.ReadRelationshipsResponse{ReadAt: &pb.ZedToken{Token: s.token()}, Relationship: r})
+	}
+
+	return st, nil
+}
+
+func (s *Server) WriteRelationships(_ context.Context, in *pb.WriteRelationshipsRequest, _ ...grpc.CallOption) (*pb.WriteRelationshipsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.record("WriteRelationships", nil); err != nil {
+		return nil, err
+	}
+	if err := s.checkPreconditions(in.OptionalPreconditions); err != nil {
+		return nil, err
+	}
+	for _, u := range in.Updates {
+		if u.Operation == pb.RelationshipUpdate_OPERATION_CREATE {
+			if _, ok := s.relationships[key(u.Relationship)]; ok {
+				return nil, status.Errorf(codes.AlreadyExists, "relationship %s already exists", key(u.Relationship))
+			}
+		}
+	}
+	updates := make([]*pb.RelationshipUpdate, 0, len(in.Updates))
+	for _, u := range in.Updates {
+		r := proto.Clone(u.Relationship).(*pb.Relationship)
+		switch u.Operation {
+		case pb.RelationshipUpdate_OPERATION_CREATE, pb.RelationshipUpdate_OPERATION_TOUCH:
+			s.relationships[key(r)] = r
+		case pb.RelationshipUpdate_OPERATION_DELETE:
+			delete(s.relationships, key(r))
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown operation %v", u.Operation)
+		}
+		updates = append(updates, &pb.RelationshipUpdate{Operation: u.Operation, Relationship: r})
+	}
+	s.commit(updates)
+
+	return &pb.WriteRelationshipsResponse{WrittenAt: &pb.ZedToken{Token: s.token()}}, nil
+}
+
+func (s *Server) DeleteRelationships(_ context.Context, in *pb.DeleteRelationshipsRequest, _ ...grpc.CallOption) (*pb.DeleteRelationshipsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.record("DeleteRelationships", nil); err != nil {
+		return nil, err
+	}
+	if err := s.checkPreconditions(in.OptionalPreconditions); err != nil {
+		return nil, err
+	}
+	var updates []*pb.RelationshipUpdate
+	for _, r := range s.sorted(func(r *pb.Relationship) bool { return matches(in.RelationshipFilter, r) }) {
+		delete(s.relationships, key(r))
+		updates = append(updates, &pb.RelationshipUpdate{Operation: pb.RelationshipUpdate_OPERATION_DELETE, Relationship: r})
+	}
+	s.commit(updates)
+
+	return &pb.DeleteRelationshipsResponse{DeletedAt: &pb.ZedToken{Token: s.token()}}, nil
+}
+
+// checkPreconditions must be called with the lock held.
+func (s *Server) checkPreconditions(ps []*pb.Precondition) error {
+	for _, p := range ps {
+		found := len(s.sorted(func(r *pb.Relationship) bool { return matches(p.Filter, r) })) > 0
+		switch p.Operation {
+		case pb.Precondition_OPERATION_MUST_MATCH:
+			if !found {
+				return status.Error(codes.FailedPrecondition, "unable to satisfy write precondition")
+			}
+		case pb.Precondition_OPERATION_MUST_NOT_MATCH:
+			if found {
+				return status.Error(codes.FailedPrecondition, "unable to satisfy write precondition")
+			}
+		default:
+			return status.Errorf(codes.InvalidArgument, "unknown precondition operation %v", p.Operation)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) CheckPermission(_ context.Context, in *pb.CheckPermissionRequest, _ ...grpc.CallOption) (*pb.CheckPermissionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.record("CheckPermission", in.Consistency); err != nil {
+		return nil, err
+	}
+	res := &pb.CheckPermissionResponse{
+		CheckedAt:      &pb.ZedToken{Token: s.token()},
+		Permissionship: pb.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION,
+	}
+	if _, ok := s.relationships[key(&pb.Relationship{Resource: in.Resource, Relation: in.Permission, Subject: in.Subject})]; ok {
+		res.Permissionship = pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION
+	}
+
+	return res, nil
+}
+
+func (s *Server) ExpandPermissionTree(_ context.Context, in *pb.ExpandPermissionTreeRequest, _ ...grpc.CallOption) (*pb.ExpandPermissionTreeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.record("ExpandPermissionTree", in.Consistency); err != nil {
+		return nil, err
+	}
+	var subjects []*pb.SubjectReference
+	for _, r := range s.sorted(func(r *pb.Relationship) bool {
+		return proto.Equal(r.Resource, in.Resource) && r.Relation == in.Permission
+	}) {
+		subjects = append(subjects, r.Subject)
+	}
+
+	return &pb.ExpandPermissionTreeResponse{
+		ExpandedAt: &pb.ZedToken{Token: s.token()},
+		TreeRoot: &pb.PermissionRelationshipTree{
+			TreeType:         &pb.PermissionRelationshipTree_Leaf{Leaf: &pb.DirectSubjectSet{Subjects: subjects}},
+			ExpandedObject:   in.Resource,
+			ExpandedRelation: in.Permission,
+		},
+	}, nil
+}
+
+func (s *Server) LookupResources(ctx context.Context, in *pb.LookupResourcesRequest, _ ...grpc.CallOption) (pb.PermissionsService_LookupResourcesClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &lookupResourcesStream{clientStream: clientStream{ctx: ctx}}
+	if st.err = s.record("LookupResources", in.Consistency); st.err != nil {
+		return st, nil
+	}
+	for _, r := range s.sorted(func(r *pb.Relationship) bool {
+		return r.Resource.ObjectType == in.ResourceObjectType && r.Relation == in.Permission && proto.Equal(r.Subject, in.Subject)
+	}) {
+		st.items = append(st.items, &pb.LookupResourcesResponse{LookedUpAt: &pb.ZedToken{Token: s.token()}, ResourceObjectId: r.Resource.ObjectId})
+	}
+
+	return st, nil
+}
+
+func (s *Server) LookupSubjects(ctx context.Context, in *pb.LookupSubjectsRequest, _ ...grpc.CallOption) (pb.PermissionsService_LookupSubjectsClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &lookupSubjectsStream{clientStream: clientStream{ctx: ctx}}
+	if st.err = s.record("LookupSubjects", in.Consistency); st.err != nil {
+		return st, nil
+	}
+	for _, r := range s.sorted(func(r *pb.Relationship) bool {
+		return proto.Equal(r.Resource, in.Resource) && r.Relation == in.Permission &&
+			r.Subject.Object.ObjectType == in.SubjectObjectType && r.Subject.OptionalRelation == in.OptionalSubjectRelation
+	}) {
+		st.items = append(st.items, &pb.LookupSubjectsResponse{LookedUpAt: &pb.ZedToken{Token: s.token()}, SubjectObjectId: r.Subject.Object.ObjectId})
+	}
+
+	return st, nil
+}
+
+// ReadSchema returns the last written schema.
+func (s *Server) ReadSchema(_ context.Context, _ *pb.ReadSchemaRequest, _ ...grpc.CallOption) (*pb.ReadSchemaResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.record("ReadSchema", nil); err != nil {
+		return nil, err
+	}
+	if s.schema == "" {
+		return nil, status.Error(codes.NotFound, "No schema has been defined; please call WriteSchema to start")
+	}
+
+	return &pb.ReadSchemaResponse{SchemaText: s.schema}, nil
+}
+
+// WriteSchema replaces the schema.
+func (s *Server) WriteSchema(_ context.Context, in *pb.WriteSchemaRequest, _ ...grpc.CallOption) (*pb.WriteSchemaResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.record("WriteSchema", nil); err != nil {
+		return nil, err
+	}
+	s.schema = in.Schema
+
+	return &pb.WriteSchemaResponse{}, nil
+}
+
+// Watch streams the changes after the start cursor or, if none is given, after the current revision.
+// Every revision is sent in its own response.
+func (s *Server) Watch(ctx context.Context, in *pb.WatchRequest, _ ...grpc.CallOption) (pb.WatchService_WatchClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &watchStream{clientStream: clientStream{ctx: ctx}, s: s, cursor: s.revision, objectTypes: in.OptionalObjectTypes}
+	if in.OptionalStartCursor != nil {
+		if st.err = s.record("Watch", &pb.Consistency{Requirement: &pb.Consistency_AtExactSnapshot{AtExactSnapshot: in.OptionalStartCursor}}); st.err != nil {
+			return st, nil
+		}
+		rev, _ := zedtoken.Decode(in.OptionalStartCursor.Token)
+		st.cursor, _ = strconv.ParseUint(rev, 10, 64)
+	} else {
+		s.requests = append(s.requests, Request{Method: "Watch"})
+	}
+
+	return st, nil
+}
+
+// key returns a unique string for the relationship, e.g. "post:1#owner@user:1".
+func key(r *pb.Relationship) string {
+	k := fmt.Sprintf("%s:%s#%s@%s:%s", r.GetResource().GetObjectType(), r.GetResource().GetObjectId(), r.GetRelation(),
+		r.GetSubject().GetObject().GetObjectType(), r.GetSubject().GetObject().GetObjectId())
+	if rel := r.GetSubject().GetOptionalRelation(); rel != "" {
+		k += "#" + rel
+	}
+
+	return k
+}
+
+// matches reports whether the relationship matches the filter.
+func matches(f *pb.RelationshipFilter, r *pb.Relationship) bool {
+	if f == nil {
+		return true
+	}
+	if f.ResourceType != r.Resource.ObjectType ||
+		(f.OptionalResourceId != "" && f.OptionalResourceId != r.Resource.ObjectId) ||
+		(f.OptionalRelation != "" && f.OptionalRelation != r.Relation) {
+		return false
+	}
+	sf := f.OptionalSubjectFilter
+	if sf == nil {
+		return true
+	}
+
+	return sf.SubjectType == r.Subject.Object.ObjectType &&
+		(sf.OptionalSubjectId == "" || sf.OptionalSubjectId == r.Subject.Object.ObjectId) &&
+		(sf.OptionalRelation == nil || sf.OptionalRelation.Relation == r.Subject.OptionalRelation)
+}
+
+// clientStream implements the grpc.ClientStream part of the streams.
+type clientStream struct {
+	ctx context.Context
+}
+
+func (cs *clientStream) Header() (metadata.MD, error) { return nil, nil }
+func (cs *clientStream) Trailer() metadata.MD         { return nil }
+func (cs *clientStream) CloseSend() error             { return nil }
+func (cs *clientStream) Context() context.Context     { return cs.ctx }
+func (cs *clientStream) SendMsg(interface{}) error {
+	return status.Error(codes.Unimplemented, "server streams do not send messages")
+}
+func (cs *clientStream) RecvMsg(interface{}) error {
+	return status.Error(codes.Unimplemented, "use Recv instead")
+}
+
+type readRelationshipsStream struct {
+	clientStream
+
+	items []*pb.ReadRelationshipsResponse
+	err   error
+}
+
+func (st *readRelationshipsStream) Recv() (*pb.ReadRelationshipsResponse, error) {
+	if st.err != nil {
+		return nil, st.err
+	}
+	if len(st.items) == 0 {
+		return nil, io.EOF
+	}
+	res := st.items[0]
+	st.items = st.items[1:]
+
+	return res, nil
+}
+
+type lookupResourcesStream struct {
+	clientStream
+
+	items []*pb.LookupResourcesResponse
+	err   error
+}
+
+func (st *lookupResourcesStream) Recv() (*pb.LookupResourcesResponse, error) {
+	if st.err != nil {
+		return nil, st.err
+	}
+	if len(st.items) == 0 {
+		return nil, io.EOF
+	}
+	res := st.items[0]
+	st.items = st.items[1:]
+
+	return res, nil
+}
+
+type lookupSubjectsStream struct {
+	clientStream
+
+	items []*pb.LookupSubjectsResponse
+	err   error
+}
+
+func (st *lookupSubjectsStream) Recv() (*pb.LookupSubjectsResponse, error) {
+	if st.err != nil {
+		return nil, st.err
+	}
+	if len(st.items) == 0 {
+		return nil, io.EOF
+	}
+	res := st.items[0]
+	st.items = st.items[1:]
+
+	return res, nil
+}
+
+type watchStream struct {
+	clientStream
+
+	s           *Server
+	cursor      uint64
+	objectTypes []string
+	err         error
+}
+
+// Recv blocks until a revision after the cursor changed a relationship of the watched object types.
+func (st *watchStream) Recv() (*pb.WatchResponse, error) {
+	if st.err != nil {
+		return nil, st.err
+	}
+	for {
+		st.s.mu.Lock()
+		changed := st.s.changed
+		for _, c := range st.s.changes {
+			if c.revision <= st.cursor {
+				continue
+			}
+			st.cursor = c.revision
+			var updates []*pb.RelationshipUpdate
+			for _, u := range c.updates {
+				if st.watched(u.Relationship.Resource.ObjectType) {
+					updates = append(updates, u)
+				}
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			st.s.mu.Unlock()
+
+			return &pb.WatchResponse{
+				Updates:        updates,
+				ChangesThrough: &pb.ZedToken{Token: zedtoken.New(strconv.FormatUint(c.revision, 10))},
+			}, nil
+		}
+		st.s.mu.Unlock()
+
+		select {
+		case <-st.ctx.Done():
+			return nil, status.FromContextError(st.ctx.Err()).Err()
+		case <-changed:
+		}
+	}
+}
+
+func (st *watchStream) watched(objectType string) bool {
+	if len(st.objectTypes) == 0 {
+		return true
+	}
+	for _, t := range st.objectTypes {
+		if t == objectType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,237 @@
+package spicedbtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/connylabs/zedcache/zedtoken"
+)
+
+func relationship(resource, subject string) *pb.Relationship {
+	return &pb.Relationship{
+		Resource: &pb.ObjectReference{ObjectType: "post", ObjectId: resource},
+		Relation: "read",
+		Subject:  &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: subject}},
+	}
+}
+
+func write(t *testing.T, s *Server, op pb.RelationshipUpdate_Operation, r *pb.Relationship) string {
+	t.Helper()
+
+	res, err := s.WriteRelationships(context.Background(), &pb.WriteRelationshipsRequest{
+		Updates: []*pb.RelationshipUpdate{{Operation: op, Relationship: r}},
+	})
+	require.NoError(t, err)
+
+	return res.WrittenAt.Token
+}
+
+func check(t *testing.T, s *Server, c *pb.Consistency, resource, subject string) (*pb.CheckPermissionResponse, error) {
+	t.Helper()
+
+	r := relationship(resource, subject)
+	return s.CheckPermission(context.Background(), &pb.CheckPermissionRequest{
+		Consistency: c,
+		Resource:    r.Resource,
+		Permission:  r.Relation,
+		Subject:     r.Subject,
+	})
+}
+
+func atLeastAsFresh(token string) *pb.Consistency {
+	return &pb.Consistency{Requirement: &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: token}}}
+}
+
+func TestServer(t *testing.T) {
+	t.Run("revisions", func(t *testing.T) {
+		s := New()
+		assert.Equal(t, zedtoken.New("1"), s.Token())
+
+		assert.Equal(t, zedtoken.New("2"), write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("1", "1")))
+		assert.Equal(t, zedtoken.New("3"), write(t, s, pb.RelationshipUpdate_OPERATION_TOUCH, relationship("1", "1")))
+		assert.Equal(t, zedtoken.New("3"), s.Token())
+
+		_, err := s.WriteRelationships(context.Background(), &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{Operation: pb.RelationshipUpdate_OPERATION_CREATE, Relationship: relationship("1", "1")}},
+		})
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		assert.Equal(t, zedtoken.New("3"), s.Token())
+	})
+
+	t.Run("check permission", func(t *testing.T) {
+		s := New()
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("1", "1"))
+
+		res, err := check(t, s, nil, "1", "1")
+		require.NoError(t, err)
+		assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, res.Permissionship)
+		assert.Equal(t, s.Token(), res.CheckedAt.Token)
+
+		res, err = check(t, s, nil, "1", "2")
+		require.NoError(t, err)
+		assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION, res.Permissionship)
+
+		write(t, s, pb.RelationshipUpdate_OPERATION_DELETE, relationship("1", "1"))
+		res, err = check(t, s, nil, "1", "1")
+		require.NoError(t, err)
+		assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION, res.Permissionship)
+	})
+
+	t.Run("consistency", func(t *testing.T) {
+		s := New()
+		old := write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("1", "1"))
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("2", "1"))
+		s.ResetRequests()
+
+		_, err := check(t, s, atLeastAsFresh(old), "1", "1")
+		assert.NoError(t, err)
+
+		_, err = check(t, s, atLeastAsFresh("invalid"), "1", "1")
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = check(t, s, atLeastAsFresh(zedtoken.New("10")), "1", "1")
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		s.GarbageCollect()
+		_, err = check(t, s, atLeastAsFresh(old), "1", "1")
+		assert.Equal(t, codes.OutOfRange, status.Code(err))
+		_, err = check(t, s, atLeastAsFresh(s.Token()), "1", "1")
+		assert.NoError(t, err)
+
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 5)
+		assert.Equal(t, old, cs[0].GetAtLeastAsFresh().GetToken())
+		assert.Len(t, s.Consistencies("WriteRelationships"), 0)
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		s := New()
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("1", "1"))
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("2", "1"))
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("2", "2"))
+
+		lr, err := s.LookupResources(context.Background(), &pb.LookupResourcesRequest{
+			ResourceObjectType: "post",
+			Permission:         "read",
+			Subject:            relationship("", "1").Subject,
+		})
+		require.NoError(t, err)
+		var ids []string
+		for {
+			res, err := lr.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			ids = append(ids, res.ResourceObjectId)
+		}
+		assert.Equal(t, []string{"1", "2"}, ids)
+
+		rr, err := s.ReadRelationships(context.Background(), &pb.ReadRelationshipsRequest{
+			RelationshipFilter: &pb.RelationshipFilter{ResourceType: "post", OptionalResourceId: "2"},
+		})
+		require.NoError(t, err)
+		var n int
+		for {
+			_, err := rr.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			n++
+		}
+		assert.Equal(t, 2, n)
+
+		// Errors are returned by the first call to Recv like by a real gRPC stream.
+		ls, err := s.LookupSubjects(context.Background(), &pb.LookupSubjectsRequest{
+			Consistency:       atLeastAsFresh("invalid"),
+			Resource:          relationship("1", "").Resource,
+			Permission:        "read",
+			SubjectObjectType: "user",
+		})
+		require.NoError(t, err)
+		_, err = ls.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("delete relationships", func(t *testing.T) {
+		s := New()
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("1", "1"))
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("2", "1"))
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("2", "2"))
+
+		res, err := s.DeleteRelationships(context.Background(), &pb.DeleteRelationshipsRequest{
+			RelationshipFilter: &pb.RelationshipFilter{
+				ResourceType:          "post",
+				OptionalSubjectFilter: &pb.SubjectFilter{SubjectType: "user", OptionalSubjectId: "1"},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, s.Token(), res.DeletedAt.Token)
+
+		for _, resource := range []string{"1", "2"} {
+			res, err := check(t, s, nil, resource, "1")
+			require.NoError(t, err)
+			assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION, res.Permissionship)
+		}
+		check2, err := check(t, s, nil, "2", "2")
+		require.NoError(t, err)
+		assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, check2.Permissionship)
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		s := New()
+		_, err := s.ReadSchema(context.Background(), &pb.ReadSchemaRequest{})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = s.WriteSchema(context.Background(), &pb.WriteSchemaRequest{Schema: "definition user {}"})
+		require.NoError(t, err)
+		res, err := s.ReadSchema(context.Background(), &pb.ReadSchemaRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "definition user {}", res.SchemaText)
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		s := New()
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("1", "1"))
+		start := s.Token()
+		write(t, s, pb.RelationshipUpdate_OPERATION_CREATE, relationship("2", "1"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w, err := s.Watch(ctx, &pb.WatchRequest{
+			OptionalObjectTypes: []string{"post"},
+			OptionalStartCursor: &pb.ZedToken{Token: start},
+		})
+		require.NoError(t, err)
+
+		res, err := w.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, zedtoken.New("3"), res.ChangesThrough.Token)
+		require.Len(t, res.Updates, 1)
+		assert.Equal(t, "2", res.Updates[0].Relationship.Resource.ObjectId)
+
+		// Recv blocks until the next write.
+		go func() {
+			_, err := s.WriteRelationships(context.Background(), &pb.WriteRelationshipsRequest{
+				Updates: []*pb.RelationshipUpdate{{Operation: pb.RelationshipUpdate_OPERATION_CREATE, Relationship: relationship("3", "1")}},
+			})
+			assert.NoError(t, err)
+		}()
+		res, err = w.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, zedtoken.New("4"), res.ChangesThrough.Token)
+
+		cancel()
+		_, err = w.Recv()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+}
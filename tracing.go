@@ -0,0 +1,149 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+const instrumentationName = "github.com/connylabs/zedcache"
+
+// Attributes that are added to the spans of the client.
+const (
+	cacheKeyAttribute     = attribute.Key("zedcache.cache.key")
+	cacheKeysAttribute    = attribute.Key("zedcache.cache.keys")
+	cacheHitAttribute     = attribute.Key("zedcache.cache.hit")
+	cacheHitsAttribute    = attribute.Key("zedcache.cache.hits")
+	cacheBackendAttribute = attribute.Key("zedcache.cache.backend")
+	consistencyAttribute  = attribute.Key("zedcache.consistency")
+)
+
+// WithTracerProvider sets the TracerProvider that is used to create spans for every request and cache operation.
+// By default the global TracerProvider is used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(pc *permissionClient) {
+		pc.tp = tp
+	}
+}
+
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(instrumentationName)
+}
+
+// recordError marks the span as failed.
+func recordError(span trace.Span, err error) {
+	if err == nil || errors.Is(err, cache.ErrCacheMiss) {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// tracedCache creates a span for every operation on the wrapped cache.
+type tracedCache struct {
+	c       cache.ContextCache
+	t       trace.Tracer
+	backend string
+}
+
+var (
+	_ cache.BatchCache     = &tracedCache{}
+	_ cache.ContextCache   = &tracedCache{}
+	_ cache.MonotonicCache = &tracedCache{}
+	_ cache.TTLCache       = &tracedCache{}
+)
+
+func (tc *tracedCache) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tc.t.Start(ctx, "zedcache.cache."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, cacheBackendAttribute.String(tc.backend))...),
+	)
+}
+
+func (tc *tracedCache) GetContext(ctx context.Context, key string) (string, error) {
+	ctx, span := tc.start(ctx, "Get", cacheKeyAttribute.String(key))
+	defer span.End()
+
+	v, err := tc.c.GetContext(ctx, key)
+	span.SetAttributes(cacheHitAttribute.Bool(err == nil))
+	recordError(span, err)
+
+	return v, err
+}
+
+func (tc *tracedCache) SetContext(ctx context.Context, key, value string) error {
+	ctx, span := tc.start(ctx, "Set", cacheKeyAttribute.String(key))
+	defer span.End()
+
+	err := tc.c.SetContext(ctx, key, value)
+	recordError(span, err)
+
+	return err
+}
+
+func (tc *tracedCache) DelContext(ctx context.Context, keys ...string) error {
+	ctx, span := tc.start(ctx, "Del", cacheKeysAttribute.StringSlice(keys))
+	defer span.End()
+
+	err := tc.c.DelContext(ctx, keys...)
+	recordError(span, err)
+
+	return err
+}
+
+func (tc *tracedCache) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	ctx, span := tc.start(ctx, "SetWithTTL", cacheKeyAttribute.String(key))
+	defer span.End()
+
+	err := cache.SetWithTTL(ctx, tc.c, key, value, ttl)
+	recordError(span, err)
+
+	return err
+}
+
+func (tc *tracedCache) SetIfNewer(ctx context.Context, key, value string, ttl time.Duration, cmp cache.Compare) error {
+	ctx, span := tc.start(ctx, "SetIfNewer", cacheKeyAttribute.String(key))
+	defer span.End()
+
+	err := cache.SetIfNewer(ctx, tc.c, key, value, ttl, cmp)
+	recordError(span, err)
+
+	return err
+}
+
+func (tc *tracedCache) GetMulti(ctx context.Context, keys ...string) (map[string]string, error) {
+	ctx, span := tc.start(ctx, "GetMulti", cacheKeysAttribute.StringSlice(keys))
+	defer span.End()
+
+	v, err := cache.GetMulti(ctx, tc.c, keys...)
+	// A batch is a hit if all keys are cached. Dependency keys are often not cached, so the number of cached keys is recorded as well.
+	span.SetAttributes(cacheHitAttribute.Bool(err == nil && len(v) == len(keys)), cacheHitsAttribute.Int(len(v)))
+	recordError(span, err)
+
+	return v, err
+}
+
+func (tc *tracedCache) SetMulti(ctx context.Context, values map[string]string, ttl time.Duration, cmp cache.Compare) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	ctx, span := tc.start(ctx, "SetMulti", cacheKeysAttribute.StringSlice(keys))
+	defer span.End()
+
+	err := cache.SetMulti(ctx, tc.c, values, ttl, cmp)
+	recordError(span, err)
+
+	return err
+}
@@ -0,0 +1,56 @@
+package zedcache
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/connylabs/zedcache/cache/go-cache"
+)
+
+func TestTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	c := NewPermissionServiceClient(&checkClient{}, gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration)), WithTracerProvider(tp))
+
+	_, err := c.CheckPermission(context.Background(), &pb.CheckPermissionRequest{
+		Permission: "read",
+		Resource:   &pb.ObjectReference{ObjectType: "post", ObjectId: "1"},
+		Subject: &pb.SubjectReference{
+			Object: &pb.ObjectReference{
+				ObjectType: "user",
+				ObjectId:   "1",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "zedcache.cache.GetMulti", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), cacheKeysAttribute.StringSlice([]string{"post#1", "->post"}))
+	assert.Contains(t, spans[0].Attributes(), cacheHitAttribute.Bool(false))
+	assert.Contains(t, spans[0].Attributes(), cacheHitsAttribute.Int(0))
+	assert.Contains(t, spans[0].Attributes(), cacheBackendAttribute.String("gocache.Cache"))
+	// The zedtoken is cached for the resource and for the missing dependency key.
+	assert.Equal(t, "zedcache.cache.SetMulti", spans[1].Name())
+
+	root := spans[2]
+	assert.Equal(t, "zedcache.CheckPermission", root.Name())
+	assert.Equal(t, root.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, root.SpanContext().SpanID(), spans[1].Parent().SpanID())
+	for _, a := range []attribute.KeyValue{
+		cacheKeyAttribute.String("post#1"),
+		cacheHitAttribute.Bool(false),
+		consistencyAttribute.String("fully_consistent"),
+	} {
+		assert.Contains(t, root.Attributes(), a)
+	}
+}
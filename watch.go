@@ -0,0 +1,194 @@
+package zedcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/connylabs/zedcache/cache"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// WatchOption allows to pass extra options to the Watcher.
+type WatchOption func(*Watcher)
+
+// WithWatchLogger can overwrite the default Noop logger of the Watcher.
+func WithWatchLogger(l log.Logger) WatchOption {
+	return func(w *Watcher) {
+		w.l = l
+	}
+}
+
+// WithStartCursor sets the zedtoken after which the Watcher starts to consume changes.
+// It can be used to resume watching from a cursor that was previously returned by Watcher.Cursor.
+// By default the Watcher only consumes changes that happen after it was started.
+func WithStartCursor(t *pb.ZedToken) WatchOption {
+	return func(w *Watcher) {
+		w.cursor = t
+	}
+}
+
+// WithObjectTypes limits the Watcher to changes of relationships with resources of the given object types.
+func WithObjectTypes(objectTypes ...string) WatchOption {
+	return func(w *Watcher) {
+		w.objectTypes = objectTypes
+	}
+}
+
+// WithBackoff configures the minimum and maximum time the Watcher waits before reconnecting.
+// The backoff is doubled for every consecutive failure and reset once a response was received.
+func WithBackoff(min, max time.Duration) WatchOption {
+	return func(w *Watcher) {
+		w.minBackoff = min
+		w.maxBackoff = max
+	}
+}
+
+// WithWatchTTL sets the time after which the zedtokens written by the Watcher expire.
+// By default the ttl is derived from DefaultGCWindow, see WithGCWindow.
+func WithWatchTTL(ttl time.Duration) WatchOption {
+	return func(w *Watcher) {
+		w.ttl = ttl
+	}
+}
+
+// Watcher consumes the Watch API and writes the zedtoken of every change into the cache.
+// Unlike the cached PermissionsServiceClient it also keeps the cache up to date with
+// changes that were written by other processes directly to SpiceDB.
+type Watcher struct {
+	c           pb.WatchServiceClient
+	ca          cache.ContextCache
+	l           log.Logger
+	objectTypes []string
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	ttl         time.Duration
+	kf          KeyFunc
+	// ns is nil unless the keys are prefixed with a namespace.
+	ns *string
+	// deps is nil unless the schema is used to update dependent object types.
+	deps *dependencies
+
+	mu     sync.Mutex
+	cursor *pb.ZedToken
+}
+
+// NewWatcher creates a new Watcher that writes the changes received from the given WatchServiceClient into the cache.
+func NewWatcher(c pb.WatchServiceClient, ca cache.Cache, opts ...WatchOption) *Watcher {
+	w := &Watcher{
+		c:          c,
+		ca:         cache.WithContext(ca),
+		l:          log.NewNopLogger(),
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		ttl:        ttlFromGCWindow(DefaultGCWindow),
+		kf:         DefaultKeyFunc,
+	}
+	for _, o := range opts {
+		o(w)
+	}
+	if w.ns != nil {
+		w.kf = namespaced(*w.ns, w.kf)
+	}
+
+	return w
+}
+
+// Cursor returns the zedtoken of the last change that was written into the cache.
+// It returns nil if no change was consumed yet.
+func (w *Watcher) Cursor() *pb.ZedToken {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.cursor
+}
+
+// Run consumes the Watch API until the given context is canceled.
+// If the stream fails, Run reconnects with an exponential backoff and
+// resumes from the last consumed cursor.
+func (w *Watcher) Run(ctx context.Context) error {
+	backoff := w.minBackoff
+	for {
+		received, err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if received {
+			backoff = w.minBackoff
+		}
+		level.Warn(w.l).Log("msg", "watch stream failed", "err", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// watch consumes a single Watch stream until it fails.
+// It reports whether at least one response was processed.
+func (w *Watcher) watch(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := w.c.Watch(ctx, &pb.WatchRequest{
+		OptionalObjectTypes: w.objectTypes,
+		OptionalStartCursor: w.Cursor(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to start watching: %w", err)
+	}
+
+	received := false
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return received, fmt.Errorf("failed to receive changes: %w", err)
+		}
+		if err := w.process(ctx, res); err != nil {
+			return received, err
+		}
+		received = true
+	}
+}
+
+// process writes the zedtoken of the given response for every affected resource, subject and dependent object type into the cache.
+// The cursor is only advanced if all entries were written, so that failed changes are consumed again after reconnecting.
+func (w *Watcher) process(ctx context.Context, res *pb.WatchResponse) error {
+	if res.ChangesThrough == nil {
+		return nil
+	}
+	deps, err := w.deps.keys(ctx, w.kf, updateRelations(res.Updates))
+	if err != nil {
+		return err
+	}
+	// The dependency keys are deleted first to reach caches that only propagate deletions, see permissionClient.update.
+	if len(deps) != 0 {
+		if err := w.ca.DelContext(ctx, deps...); err != nil {
+			return fmt.Errorf("failed to delete cache entry: %w", err)
+		}
+	}
+	if err := setAll(ctx, w.ca, w.ttl, append(relationshipKeys(w.kf, res.Updates), deps...), res.ChangesThrough.Token); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	w.mu.Lock()
+	w.cursor = res.ChangesThrough
+	w.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package zedcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/zedtoken"
+)
+
+func TestWatcher(t *testing.T) {
+	update := func(resource, subject string) *pb.RelationshipUpdate {
+		return &pb.RelationshipUpdate{
+			Operation: pb.RelationshipUpdate_OPERATION_TOUCH,
+			Relationship: &pb.Relationship{
+				Resource: &pb.ObjectReference{ObjectType: "post", ObjectId: resource},
+				Relation: "owner",
+				Subject:  &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: subject}},
+			},
+		}
+	}
+
+	wc := &fakeWatchClient{
+		streams: [][]*pb.WatchResponse{
+			{
+				{Updates: []*pb.RelationshipUpdate{update("1", "1")}, ChangesThrough: &pb.ZedToken{Token: zedtoken.New("1")}},
+			},
+			{
+				{Updates: []*pb.RelationshipUpdate{update("2", "1")}, ChangesThrough: &pb.ZedToken{Token: zedtoken.New("2")}},
+			},
+		},
+	}
+	ca := gocache.New(gcache.New(gcache.NoExpiration, gcache.NoExpiration))
+	w := NewWatcher(wc, ca, WithBackoff(time.Millisecond, time.Millisecond), WithObjectTypes("post"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return w.Cursor() != nil && w.Cursor().Token == zedtoken.New("2")
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	for k, v := range map[string]string{"post#1": zedtoken.New("1"), "post#2": zedtoken.New("2"), "user#1": zedtoken.New("2")} {
+		got, err := ca.Get(k)
+		assert.NoError(t, err)
+		assert.Equal(t, v, got, k)
+	}
+
+	reqs := wc.requests()
+	require.GreaterOrEqual(t, len(reqs), 2)
+	assert.Nil(t, reqs[0].OptionalStartCursor)
+	assert.Equal(t, []string{"post"}, reqs[0].OptionalObjectTypes)
+	assert.Equal(t, zedtoken.New("1"), reqs[1].OptionalStartCursor.Token)
+}
+
+type fakeWatchClient struct {
+	mu      sync.Mutex
+	streams [][]*pb.WatchResponse
+	reqs    []*pb.WatchRequest
+}
+
+func (f *fakeWatchClient) Watch(ctx context.Context, in *pb.WatchRequest, _ ...grpc.CallOption) (pb.WatchService_WatchClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reqs = append(f.reqs, in)
+	var res []*pb.WatchResponse
+	if len(f.streams) > 0 {
+		res, f.streams = f.streams[0], f.streams[1:]
+	}
+
+	return &fakeWatchStream{ctx: ctx, res: res}, nil
+}
+
+func (f *fakeWatchClient) requests() []*pb.WatchRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*pb.WatchRequest(nil), f.reqs...)
+}
+
+type fakeWatchStream struct {
+	grpc.ClientStream
+
+	ctx context.Context
+	res []*pb.WatchResponse
+}
+
+func (f *fakeWatchStream) Recv() (*pb.WatchResponse, error) {
+	if len(f.res) == 0 {
+		return nil, errors.New("stream closed")
+	}
+	res := f.res[0]
+	f.res = f.res[1:]
+
+	return res, nil
+}
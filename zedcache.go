@@ -2,16 +2,24 @@ package zedcache
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"strings"
+	"time"
 
 	pb "github.com/authzed/authzed-go/proto/authzed/api/v1"
 	"github.com/authzed/authzed-go/v1"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/connylabs/zedcache/cache"
+	"github.com/connylabs/zedcache/zedtoken"
 )
 
 // Options allows to pass extra options to the PermissionsServiceClient implementation.
@@ -25,6 +33,43 @@ func WithLogger(l log.Logger) Option {
 	}
 }
 
+// DefaultGCWindow is the default duration for which SpiceDB keeps old revisions.
+// Zedtokens of revisions that are older than the GC window can not be used anymore.
+const DefaultGCWindow = 24 * time.Hour
+
+// WithTTL sets the time after which cached zedtokens expire.
+// A ttl of 0 means that cached zedtokens never expire.
+// The ttl should be shorter than SpiceDB's GC window.
+// Caches that do not implement cache.TTLCache ignore the ttl.
+func WithTTL(ttl time.Duration) Option {
+	return func(pc *permissionClient) {
+		pc.ttl = ttl
+	}
+}
+
+// WithGCWindow derives the ttl of cached zedtokens from SpiceDB's GC window,
+// i.e. the value of SpiceDB's --datastore-gc-window flag.
+// By default the ttl is derived from DefaultGCWindow.
+func WithGCWindow(window time.Duration) Option {
+	return WithTTL(ttlFromGCWindow(window))
+}
+
+// ttlFromGCWindow leaves a margin of a tenth of the GC window,
+// because the cached zedtokens can already be older than the entries.
+func ttlFromGCWindow(window time.Duration) time.Duration {
+	return window - window/10
+}
+
+// WithStaleTokenFallback sets the consistency that is used if SpiceDB rejects a cached zedtoken,
+// e.g. because it is older than SpiceDB's GC window.
+// The rejected zedtoken is removed from the cache and the request is retried with the given consistency.
+// By default the fallback consistency is FullyConsistent.
+func WithStaleTokenFallback(c *pb.Consistency) Option {
+	return func(pc *permissionClient) {
+		pc.fallback = c
+	}
+}
+
 // New is a helper function to add a cache to the authzed.Client's PermissionsServiceClient implementation.
 func New(c *authzed.Client, ca cache.Cache, opts ...Option) *authzed.Client {
 	return &authzed.Client{
@@ -37,42 +82,250 @@ func New(c *authzed.Client, ca cache.Cache, opts ...Option) *authzed.Client {
 // NewPermissionServiceClient add a cache to the given PermissionsServiceClient.
 // Note that unlike the original interface the default Consistency is FullyConsistent.
 func NewPermissionServiceClient(c pb.PermissionsServiceClient, ca cache.Cache, opts ...Option) pb.PermissionsServiceClient {
+	return newPermissionClient(ca, opts...).with(c)
+}
+
+// newPermissionClient creates a permissionClient without an upstream client.
+func newPermissionClient(ca cache.Cache, opts ...Option) *permissionClient {
 	pc := &permissionClient{
-		PermissionsServiceClient: c,
-		ca:                       ca,
-		l:                        log.NewNopLogger(),
+		l:         log.NewNopLogger(),
+		ttl:       ttlFromGCWindow(DefaultGCWindow),
+		fallback:  fullyConsistent(),
+		getPolicy: Degrade,
+		setPolicy: Proceed,
+		delPolicy: Block,
+		dk:        newDegradedKeys(),
+		kf:        DefaultKeyFunc,
 	}
 	for _, o := range opts {
 		o(pc)
 	}
+	pc.m = newMetrics(pc.r)
+	if pc.backend == "" {
+		pc.backend = backendName(ca)
+	}
+	pc.t = newTracer(pc.tp)
+	pc.ca = &instrumentedCache{
+		c:       &tracedCache{c: cache.WithContext(ca), t: pc.t, backend: pc.backend},
+		m:       pc.m,
+		backend: pc.backend,
+	}
+	if pc.breaker != nil {
+		pc.ca = newBreakerCache(pc.ca, *pc.breaker, pc.m, pc.backend)
+	}
 
 	return pc
 }
 
+// with returns a copy of the permissionClient that sends requests to the given upstream client.
+func (c *permissionClient) with(upstream pb.PermissionsServiceClient) *permissionClient {
+	pc := *c
+	pc.PermissionsServiceClient = &instrumentedClient{PermissionsServiceClient: upstream, m: c.m}
+
+	return &pc
+}
+
 type permissionClient struct {
 	pb.PermissionsServiceClient
 
-	ca cache.Cache
-	l  log.Logger
+	ca       cache.ContextCache
+	l        log.Logger
+	ttl      time.Duration
+	fallback *pb.Consistency
+	r        prometheus.Registerer
+	m        *metrics
+	backend  string
+	tp       trace.TracerProvider
+	t        trace.Tracer
+	co       *coalescer
+
+	getPolicy Policy
+	setPolicy Policy
+	delPolicy Policy
+	breaker   *gobreaker.Settings
+	// dk holds the keys whose zedtokens could not be invalidated.
+	dk *degradedKeys
+	kf KeyFunc
+	// ns returns the namespace of a request's cache keys. It is nil unless a namespace is set.
+	ns func(context.Context) string
+
+	// deps is nil unless the schema is used to invalidate dependent object types.
+	deps         *dependencies
+	invalidation Invalidation
+}
+
+// startSpan starts a span for the given method of the PermissionsService.
+func (c *permissionClient) startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return c.t.Start(ctx, "zedcache."+method)
+}
+
+// consistency sets the consistency requirement of a request that does not specify one.
+// If a zedtoken is cached for all given keys, the request is evaluated at least as fresh as the newest of them.
+// Otherwise the request is evaluated fully consistently.
+// The consistency of a context with WithFullConsistency, WithMinimizeLatency or WithoutCache is used instead of the cache.
+// It reports whether the cached zedtokens are used and returns the dependency keys that were not cached.
+// They must be cached with the zedtoken of the response, see lookupAll.
+// If a lookup fails, it returns an error according to the Get policy.
+func (c *permissionClient) consistency(ctx context.Context, method string, consistency **pb.Consistency, keys ...Key) (bool, []Key, error) {
+	if *consistency != nil && (*consistency).Requirement != nil {
+		return false, nil, nil
+	}
+	if o := overrideFromContext(ctx); o.overridden() {
+		o.apply(consistency)
+		return false, nil, nil
+	}
+	encoded := encodeKeys(c.keyFunc(ctx), keys)
+	for _, k := range encoded {
+		if c.dk.has(k) {
+			*consistency = fullyConsistent()
+			return false, nil, nil
+		}
+	}
+
+	t, seeds, err := c.lookupAll(ctx, keys)
+	c.m.lookup(method, c.backend, err)
+	keyAttribute := cacheKeysAttribute.StringSlice(encoded)
+	if len(encoded) == 1 {
+		keyAttribute = cacheKeyAttribute.String(encoded[0])
+	}
+	trace.SpanFromContext(ctx).SetAttributes(keyAttribute, cacheHitAttribute.Bool(err == nil))
+	if err != nil {
+		if !errors.Is(err, cache.ErrCacheMiss) && c.getPolicy != Degrade {
+			// Under the Proceed policy, the request is sent without a consistency requirement.
+			*consistency = nil
+			return false, nil, c.lookupFailed(err)
+		}
+		*consistency = fullyConsistent()
+		return false, seeds, nil
+	}
+	*consistency = &pb.Consistency{Requirement: &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: t}}}
+
+	return true, nil, nil
+}
+
+// lookupAll returns the newest of the zedtokens that are cached for the keys,
+// after applying the zedtokens of the writes that changed their object types through other objects.
+// All keys and dependency keys are looked up with a single cache call.
+// It returns ErrCacheMiss if any of the keys is not cached.
+// A dependency key that is not cached could have been evicted after a write, so it is a miss as well.
+// The missing dependency keys are returned, so that the zedtoken of the fully consistent request can be cached for them.
+func (c *permissionClient) lookupAll(ctx context.Context, keys []Key) (string, []Key, error) {
+	kf := c.keyFunc(ctx)
+	encoded := encodeKeys(kf, keys)
+	deps := dependencyLookups(kf, keys)
+	lookups := append([]string(nil), encoded...)
+	for _, dk := range deps {
+		if c.dk.has(dk) {
+			return "", nil, cache.ErrCacheMiss
+		}
+		lookups = append(lookups, dk)
+	}
+	values, err := c.lookupMulti(ctx, lookups)
+	if err != nil {
+		return "", nil, err
+	}
+	var missing []Key
+	for t, dk := range deps {
+		if _, ok := values[dk]; !ok {
+			missing = append(missing, dependencyKey(t))
+		}
+	}
+	if len(missing) != 0 {
+		return "", missing, cache.ErrCacheMiss
+	}
+	var token string
+	for i, k := range keys {
+		t, ok := values[encoded[i]]
+		if !ok {
+			return "", nil, cache.ErrCacheMiss
+		}
+		if t, err = c.applyDependency(t, values[deps[k.ObjectType]]); err != nil {
+			return "", nil, err
+		}
+		if i == 0 || cache.IsNewer(t, token, zedtoken.Compare) {
+			token = t
+		}
+	}
+
+	return token, nil, nil
+}
+
+func fullyConsistent() *pb.Consistency {
+	return &pb.Consistency{Requirement: &pb.Consistency_FullyConsistent{FullyConsistent: true}}
+}
+
+// fallbackConsistency returns a copy of the consistency that is used if no zedtoken is cached.
+func (c *permissionClient) fallbackConsistency() *pb.Consistency {
+	return proto.Clone(c.fallback).(*pb.Consistency)
+}
+
+// staleToken reports whether the request failed because the cached zedtoken was expired or invalid.
+// If so, the cached zedtokens of the keys are removed.
+func (c *permissionClient) staleToken(ctx context.Context, err error, method string, ks ...Key) bool {
+	if !isStaleTokenError(err) {
+		return false
+	}
+	keys := encodeKeys(c.keyFunc(ctx), ks)
+
+	c.m.staleTokens.WithLabelValues(method).Inc()
+	c.m.invalidations.WithLabelValues(method, c.backend).Add(float64(len(keys)))
+	level.Warn(c.l).Log("msg", "cached zedtoken was rejected, retrying with fallback consistency", "keys", strings.Join(keys, ","), "err", err.Error())
+	if err := c.ca.DelContext(ctx, keys...); err != nil {
+		level.Error(c.l).Log("msg", "failed to delete cache entry", "err", err.Error())
+	}
+
+	return true
+}
+
+// isStaleTokenError reports whether SpiceDB rejected a zedtoken, e.g.
+// because its revision was already garbage collected or it could not be decoded.
+func isStaleTokenError(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok || err == nil {
+		return false
+	}
+	switch s.Code() {
+	case codes.OutOfRange:
+		return true
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		msg := strings.ToLower(s.Message())
+		return strings.Contains(msg, "zedtoken") || strings.Contains(msg, "revision")
+	default:
+		return false
+	}
 }
 
 type permissionsService_ReadRelationshipsClient struct {
 	pb.PermissionsService_ReadRelationshipsClient
 
-	c                cache.Cache
-	recourceCacheKey string
-	cached           bool
-	l                log.Logger
+	ctx              context.Context
+	pc               *permissionClient
+	recourceCacheKey Key
+	// seeds are the dependency keys that are cached with the zedtoken of the response.
+	seeds  []Key
+	cached bool
+	// retry reissues the request with the fallback consistency.
+	// It is nil if the request did not use a cached zedtoken.
+	retry func() (pb.PermissionsService_ReadRelationshipsClient, error)
 }
 
 func (rrc *permissionsService_ReadRelationshipsClient) Recv() (*pb.ReadRelationshipsResponse, error) {
 	ret, err := rrc.PermissionsService_ReadRelationshipsClient.Recv()
+	if rrc.retry != nil && rrc.pc.staleToken(rrc.ctx, err, "ReadRelationships", rrc.recourceCacheKey) {
+		stream, rerr := rrc.retry()
+		if rerr != nil {
+			return nil, rerr
+		}
+		rrc.PermissionsService_ReadRelationshipsClient = stream
+		ret, err = stream.Recv()
+	}
+	rrc.retry = nil
 	if err != nil || rrc.cached {
 		return ret, err
 	}
 	if ret.ReadAt != nil {
-		if err := rrc.c.Set(rrc.recourceCacheKey, ret.ReadAt.Token); err != nil {
-			level.Error(rrc.l).Log("msg", "failed to write cache entry", "err", err.Error())
+		if err := rrc.pc.set(rrc.ctx, ret.ReadAt.Token, append([]Key{rrc.recourceCacheKey}, rrc.seeds...)...); err != nil {
+			return nil, err
 		}
 	}
 
@@ -84,108 +337,132 @@ func (rrc *permissionsService_ReadRelationshipsClient) Recv() (*pb.ReadRelations
 // ReadRelationships reads a set of the relationships matching one or more
 // filters.
 func (c *permissionClient) ReadRelationships(ctx context.Context, in *pb.ReadRelationshipsRequest, opts ...grpc.CallOption) (pb.PermissionsService_ReadRelationshipsClient, error) {
-	if in.Consistency == nil {
-		in.Consistency = &pb.Consistency{}
-	}
-	if in.Consistency.Requirement == nil {
-		in.Consistency.Requirement = &pb.Consistency_FullyConsistent{FullyConsistent: true}
-
-		// Not sure how to cache zedtoken if we the caller does not specify a resource id.
-		if in.RelationshipFilter.OptionalResourceId != "" {
-			t, err := c.ca.Get(fmt.Sprintf("%s#%s", in.RelationshipFilter.ResourceType, in.RelationshipFilter.OptionalResourceId))
-			if err == nil {
-				in.Consistency.Requirement = &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: t}}
-			}
-		}
+	ctx, span := c.startSpan(ctx, "ReadRelationships")
+	defer span.End()
 
+	// Without a resource id, the relationships of all resources of the type are read.
+	key := typeKey(in.RelationshipFilter.ResourceType)
+	if in.RelationshipFilter.OptionalResourceId != "" {
+		key = Key{ObjectType: in.RelationshipFilter.ResourceType, ObjectID: in.RelationshipFilter.OptionalResourceId}
+	}
+	fromCache, seeds, err := c.consistency(ctx, "ReadRelationships", &in.Consistency, key)
+	if err != nil {
+		return nil, err
 	}
-
 	ret, err := c.PermissionsServiceClient.ReadRelationships(ctx, in, opts...)
-	// Not sure how to cache zedtoken if we the caller does not specify a resource id.
-	if err != nil || in.RelationshipFilter.OptionalResourceId == "" {
+	if fromCache && c.staleToken(ctx, err, "ReadRelationships", key) {
+		in.Consistency = c.fallbackConsistency()
+		fromCache = false
+		ret, err = c.PermissionsServiceClient.ReadRelationships(ctx, in, opts...)
+	}
+	if err != nil {
 		return ret, err
 	}
 
-	return &permissionsService_ReadRelationshipsClient{
+	rc := &permissionsService_ReadRelationshipsClient{
 		PermissionsService_ReadRelationshipsClient: ret,
-		c:                c.ca,
-		recourceCacheKey: fmt.Sprintf("%s#%s", in.RelationshipFilter.ResourceType, in.RelationshipFilter.OptionalResourceId),
-		l:                c.l,
-	}, err
+		ctx:              ctx,
+		pc:               c,
+		recourceCacheKey: key,
+		seeds:            seeds,
+	}
+	if fromCache {
+		rc.retry = func() (pb.PermissionsService_ReadRelationshipsClient, error) {
+			in.Consistency = c.fallbackConsistency()
+			return c.PermissionsServiceClient.ReadRelationships(ctx, in, opts...)
+		}
+	}
+
+	return rc, nil
 }
 
 // CheckPermission determines for a given resource whether a subject computes
 // to having a permission or is a direct member of a particular relation.
 func (c *permissionClient) CheckPermission(ctx context.Context, in *pb.CheckPermissionRequest, opts ...grpc.CallOption) (*pb.CheckPermissionResponse, error) {
-	if in.Consistency == nil {
-		in.Consistency = &pb.Consistency{}
-	}
-	if in.Consistency.Requirement == nil {
-		in.Consistency.Requirement = &pb.Consistency_FullyConsistent{FullyConsistent: true}
+	ctx, span := c.startSpan(ctx, "CheckPermission")
+	defer span.End()
 
-		t, err := c.ca.Get(sprintObjectReference(in.Resource))
-		if err == nil {
-			in.Consistency.Requirement = &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: t}}
-		}
+	key := objectKey(in.Resource)
+	fromCache, seeds, release, err := c.coalescedConsistency(ctx, "CheckPermission", &in.Consistency, key)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 	ret, err := c.PermissionsServiceClient.CheckPermission(ctx, in, opts...)
+	if fromCache && c.staleToken(ctx, err, "CheckPermission", key) {
+		in.Consistency = c.fallbackConsistency()
+		ret, err = c.PermissionsServiceClient.CheckPermission(ctx, in, opts...)
+	}
 	if err != nil {
 		return ret, err
 	}
-	fmt.Println(sprintObjectReference(in.Resource))
-	if err := c.ca.Set(sprintObjectReference(in.Resource), ret.CheckedAt.Token); err != nil {
-		level.Error(c.l).Log("msg", "failed to write cache entry", "err", err.Error())
+	if err := c.set(ctx, ret.CheckedAt.Token, append([]Key{key}, seeds...)...); err != nil {
+		return nil, err
 	}
 
-	return ret, err
+	return ret, nil
 }
 
 // ExpandPermissionTree reveals the graph structure for a resource's
 // permission or relation. This RPC does not recurse infinitely deep and may
 // require multiple calls to fully unnest a deeply nested graph.
 func (c *permissionClient) ExpandPermissionTree(ctx context.Context, in *pb.ExpandPermissionTreeRequest, opts ...grpc.CallOption) (*pb.ExpandPermissionTreeResponse, error) {
-	if in.Consistency == nil {
-		in.Consistency = &pb.Consistency{}
-	}
-	if in.Consistency.Requirement == nil {
-		in.Consistency.Requirement = &pb.Consistency_FullyConsistent{FullyConsistent: true}
+	ctx, span := c.startSpan(ctx, "ExpandPermissionTree")
+	defer span.End()
 
-		t, err := c.ca.Get(sprintObjectReference(in.Resource))
-		if err == nil {
-			in.Consistency.Requirement = &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: t}}
-		}
+	key := objectKey(in.Resource)
+	fromCache, seeds, release, err := c.coalescedConsistency(ctx, "ExpandPermissionTree", &in.Consistency, key)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 	ret, err := c.PermissionsServiceClient.ExpandPermissionTree(ctx, in, opts...)
+	if fromCache && c.staleToken(ctx, err, "ExpandPermissionTree", key) {
+		in.Consistency = c.fallbackConsistency()
+		ret, err = c.PermissionsServiceClient.ExpandPermissionTree(ctx, in, opts...)
+	}
 	if err != nil {
 		return ret, err
 	}
 
 	if ret.ExpandedAt != nil {
-		if err := c.ca.Set(sprintObjectReference(in.Resource), ret.ExpandedAt.Token); err != nil {
-			level.Error(c.l).Log("msg", "failed to write cache entry", "err", err.Error())
+		if err := c.set(ctx, ret.ExpandedAt.Token, append([]Key{key}, seeds...)...); err != nil {
+			return nil, err
 		}
 	}
 
-	return ret, err
+	return ret, nil
 }
 
 type permissionsService_LookupResourcesClient struct {
 	pb.PermissionsService_LookupResourcesClient
 
-	c                    cache.Cache
-	parentObjectCacheKey string
-	cached               bool
-	l                    log.Logger
+	ctx    context.Context
+	pc     *permissionClient
+	keys   []Key
+	cached bool
+	// retry reissues the request with the fallback consistency.
+	// It is nil if the request did not use a cached zedtoken.
+	retry func() (pb.PermissionsService_LookupResourcesClient, error)
 }
 
 func (lrc *permissionsService_LookupResourcesClient) Recv() (*pb.LookupResourcesResponse, error) {
 	ret, err := lrc.PermissionsService_LookupResourcesClient.Recv()
+	if lrc.retry != nil && lrc.pc.staleToken(lrc.ctx, err, "LookupResources", lrc.keys...) {
+		stream, rerr := lrc.retry()
+		if rerr != nil {
+			return nil, rerr
+		}
+		lrc.PermissionsService_LookupResourcesClient = stream
+		ret, err = stream.Recv()
+	}
+	lrc.retry = nil
 	if err != nil || lrc.cached {
 		return ret, err
 	}
 	if ret.LookedUpAt != nil {
-		if err := lrc.c.Set(lrc.parentObjectCacheKey, ret.LookedUpAt.Token); err != nil {
-			level.Error(lrc.l).Log("msg", "failed to write cache entry", "err", err.Error())
+		if err := lrc.pc.set(lrc.ctx, ret.LookedUpAt.Token, lrc.keys...); err != nil {
+			return nil, err
 		}
 	}
 
@@ -196,52 +473,75 @@ func (lrc *permissionsService_LookupResourcesClient) Recv() (*pb.LookupResources
 
 // LookupResources returns all the resources of a given type that a subject
 // can access whether via a computed permission or relation membership.
+// The request is evaluated at least as fresh as the zedtokens of the subject and of the resource type.
 func (c *permissionClient) LookupResources(ctx context.Context, in *pb.LookupResourcesRequest, opts ...grpc.CallOption) (pb.PermissionsService_LookupResourcesClient, error) {
-	if in.Consistency == nil {
-		in.Consistency = &pb.Consistency{}
-	}
-	if in.Consistency.Requirement == nil {
-		in.Consistency.Requirement = &pb.Consistency_FullyConsistent{FullyConsistent: true}
+	ctx, span := c.startSpan(ctx, "LookupResources")
+	defer span.End()
 
-		t, err := c.ca.Get(sprintSubjectReference(in.Subject))
-		if err == nil {
-			in.Consistency.Requirement = &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: t}}
-		}
+	keys := []Key{subjectKey(in.Subject), typeKey(in.ResourceObjectType)}
+	fromCache, seeds, err := c.consistency(ctx, "LookupResources", &in.Consistency, keys...)
+	if err != nil {
+		return nil, err
 	}
 	ret, err := c.PermissionsServiceClient.LookupResources(ctx, in, opts...)
+	if fromCache && c.staleToken(ctx, err, "LookupResources", keys...) {
+		in.Consistency = c.fallbackConsistency()
+		fromCache = false
+		ret, err = c.PermissionsServiceClient.LookupResources(ctx, in, opts...)
+	}
 	if err != nil {
 		return ret, err
 	}
 
 	nr := &permissionsService_LookupResourcesClient{
 		PermissionsService_LookupResourcesClient: ret,
-		c:                                        c.ca,
-		parentObjectCacheKey:                     sprintSubjectReference(in.Subject),
-		l:                                        c.l,
+		ctx:                                      ctx,
+		pc:                                       c,
+		keys:                                     append(keys, seeds...),
+	}
+	if fromCache {
+		nr.retry = func() (pb.PermissionsService_LookupResourcesClient, error) {
+			in.Consistency = c.fallbackConsistency()
+			return c.PermissionsServiceClient.LookupResources(ctx, in, opts...)
+		}
 	}
-	return nr, err
+	return nr, nil
 }
 
 type permissionsService_LookupSubjectsClient struct {
 	pb.PermissionsService_LookupSubjectsClient
 
-	c                 cache.Cache
-	parentResourceKey string
-	cached            bool
-	l                 log.Logger
+	ctx    context.Context
+	pc     *permissionClient
+	keys   []Key
+	cached bool
+	// retry reissues the request with the fallback consistency.
+	// It is nil if the request did not use a cached zedtoken.
+	retry func() (pb.PermissionsService_LookupSubjectsClient, error)
 }
 
 func (lsc *permissionsService_LookupSubjectsClient) Recv() (*pb.LookupSubjectsResponse, error) {
 	ret, err := lsc.PermissionsService_LookupSubjectsClient.Recv()
+	if lsc.retry != nil && lsc.pc.staleToken(lsc.ctx, err, "LookupSubjects", lsc.keys...) {
+		stream, rerr := lsc.retry()
+		if rerr != nil {
+			return nil, rerr
+		}
+		lsc.PermissionsService_LookupSubjectsClient = stream
+		ret, err = stream.Recv()
+	}
+	lsc.retry = nil
 	if err != nil {
 		return ret, err
 	}
 	// TODO: does it make sense to cache the token along the resource here?
 	if !lsc.cached {
-		if err := lsc.c.Set(lsc.parentResourceKey, ret.LookedUpAt.Token); err != nil {
-			level.Error(lsc.l).Log("msg", "failed to write cache entry", "err", err.Error())
-		}
 		lsc.cached = true
+		if ret.LookedUpAt != nil {
+			if err := lsc.pc.set(lsc.ctx, ret.LookedUpAt.Token, lsc.keys...); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return ret, err
@@ -249,29 +549,40 @@ func (lsc *permissionsService_LookupSubjectsClient) Recv() (*pb.LookupSubjectsRe
 
 // LookupSubjects returns all the subjects of a given type that
 // have access whether via a computed permission or relation membership.
+// The request is evaluated at least as fresh as the zedtokens of the resource and of the subject type.
 func (c *permissionClient) LookupSubjects(ctx context.Context, in *pb.LookupSubjectsRequest, opts ...grpc.CallOption) (pb.PermissionsService_LookupSubjectsClient, error) {
-	if in.Consistency == nil {
-		in.Consistency = &pb.Consistency{}
-	}
-	if in.Consistency.Requirement == nil {
-		in.Consistency.Requirement = &pb.Consistency_FullyConsistent{FullyConsistent: true}
+	ctx, span := c.startSpan(ctx, "LookupSubjects")
+	defer span.End()
 
-		t, err := c.ca.Get(sprintObjectReference(in.Resource))
-		if err == nil {
-			in.Consistency.Requirement = &pb.Consistency_AtLeastAsFresh{AtLeastAsFresh: &pb.ZedToken{Token: t}}
-		}
+	keys := []Key{objectKey(in.Resource), typeKey(in.SubjectObjectType)}
+	fromCache, seeds, err := c.consistency(ctx, "LookupSubjects", &in.Consistency, keys...)
+	if err != nil {
+		return nil, err
 	}
 	ret, err := c.PermissionsServiceClient.LookupSubjects(ctx, in, opts...)
+	if fromCache && c.staleToken(ctx, err, "LookupSubjects", keys...) {
+		in.Consistency = c.fallbackConsistency()
+		fromCache = false
+		ret, err = c.PermissionsServiceClient.LookupSubjects(ctx, in, opts...)
+	}
 	if err != nil {
 		return ret, err
 	}
 
-	return &permissionsService_LookupSubjectsClient{
+	sc := &permissionsService_LookupSubjectsClient{
 		PermissionsService_LookupSubjectsClient: ret,
-		c:                                       c.ca,
-		parentResourceKey:                       sprintObjectReference(in.Resource),
-		l:                                       c.l,
-	}, err
+		ctx:                                     ctx,
+		pc:                                      c,
+		keys:                                    append(keys, seeds...),
+	}
+	if fromCache {
+		sc.retry = func() (pb.PermissionsService_LookupSubjectsClient, error) {
+			in.Consistency = c.fallbackConsistency()
+			return c.PermissionsServiceClient.LookupSubjects(ctx, in, opts...)
+		}
+	}
+
+	return sc, nil
 }
 
 // WriteRelationships atomically writes and/or deletes a set of specified
@@ -282,57 +593,113 @@ func (c *permissionClient) LookupSubjects(ctx context.Context, in *pb.LookupSubj
 // The authors suggest to only save the zedtoken along the parent resource.
 // However it is not clear how to determine whether a resource was added/removed or a relation was added/removed.
 func (c *permissionClient) WriteRelationships(ctx context.Context, in *pb.WriteRelationshipsRequest, opts ...grpc.CallOption) (*pb.WriteRelationshipsResponse, error) {
-	{
-		// delete all relevant cached zed token to avoid the "New Enimy" problem.
-		keys := make([]string, 2*len(in.Updates))
-		for i, r := range in.Updates {
-			keys[i] = sprintObjectReference(r.Relationship.Resource)
-			keys[2*i] = sprintSubjectReference(r.Relationship.Subject)
-		}
-		if err := c.ca.Del(keys...); err != nil {
-			return nil, fmt.Errorf("failed to clear cache: %w", err)
-		}
+	ctx, span := c.startSpan(ctx, "WriteRelationships")
+	defer span.End()
+
+	// delete all relevant cached zed token to avoid the "New Enimy" problem.
+	keys := relationshipKeys(c.keyFunc(ctx), in.Updates)
+	deps, err := c.dependencyKeys(ctx, updateRelations(in.Updates))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.invalidate(ctx, "WriteRelationships", keys); err != nil {
+		return nil, err
 	}
 	res, err := c.PermissionsServiceClient.WriteRelationships(ctx, in, opts...)
+	// Requests that start after the write must not share lookups that started before.
+	defer c.forget(append(keys, deps...)...)
 	if err != nil {
 		return res, err
 	}
 
-	g := multierror.Group{}
-	for _, r := range in.Updates {
-		rKey := sprintObjectReference(r.Relationship.Resource)
-		fmt.Println(rKey)
-		g.Go(func() error {
-			return c.ca.Set(rKey, res.WrittenAt.Token)
-		})
-		sKey := sprintSubjectReference(r.Relationship.Subject)
-		fmt.Println(sKey)
-		g.Go(func() error {
-			return c.ca.Set(sKey, res.WrittenAt.Token)
-		})
-	}
 	// We don't need block for writing the updated valued to the cache here, because we already deleted the relevant cache entries.
 	// But it would make the testing more difficult, so no async writes here at first.
-	//	go func() {
-	if err := g.Wait().ErrorOrNil(); err != nil {
-		level.Error(c.l).Log("msg", "failed to write cache entry", "err", err.Error())
+	if err := c.update(ctx, keys, deps, res.WrittenAt.Token); err != nil {
+		return nil, err
 	}
-	//	}()
 	return res, nil
 }
 
-func sprintObjectReference(r *pb.ObjectReference) string {
-	if r == nil {
-		panic("can not print nil object reference")
+// DeleteRelationships atomically bulk deletes all relationships matching the
+// provided filter. If no relationships match, none will be deleted and the
+// operation will succeed. An optional set of preconditions can be provided that must
+// be satisfied for the operation to commit.
+// The resources and subjects named by the filter are removed from the cache before
+// the relationships are deleted and are cached with the returned zedtoken afterwards.
+// If the filter does not name the resource, the returned zedtoken is also cached for the resource type,
+// so that requests for any resource of the type are evaluated at least as fresh as the deletion.
+func (c *permissionClient) DeleteRelationships(ctx context.Context, in *pb.DeleteRelationshipsRequest, opts ...grpc.CallOption) (*pb.DeleteRelationshipsResponse, error) {
+	ctx, span := c.startSpan(ctx, "DeleteRelationships")
+	defer span.End()
+
+	keys := c.filterKeys(ctx, in.RelationshipFilter)
+	var deps []string
+	if f := in.RelationshipFilter; f != nil {
+		var err error
+		deps, err = c.dependencyKeys(ctx, map[[2]string]struct{}{{f.ResourceType, f.OptionalRelation}: {}})
+		if err != nil {
+			return nil, err
+		}
+		// The deleted relationships of resources that the filter does not name are not known,
+		// so all resources of the type are evaluated at least as fresh as the deletion.
+		if f.OptionalResourceId == "" {
+			deps = append(deps, c.keyFunc(ctx)(dependencyKey(f.ResourceType)))
+		}
+	}
+	// delete all relevant cached zed token to avoid the "New Enimy" problem.
+	if err := c.invalidate(ctx, "DeleteRelationships", keys); err != nil {
+		return nil, err
+	}
+	res, err := c.PermissionsServiceClient.DeleteRelationships(ctx, in, opts...)
+	// Requests that start after the deletion must not share lookups that started before.
+	defer c.forget(append(keys, deps...)...)
+	if err != nil {
+		return res, err
+	}
+
+	if res.DeletedAt != nil {
+		if err := c.update(ctx, keys, deps, res.DeletedAt.Token); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// filterKeys returns the cache keys that are affected by the given filter.
+// They are derived from the filter alone: the keys of the object types and, if the filter names them, of the resource and the subject.
+// Resources that the filter does not name are invalidated by the dependency key of the resource type, see DeleteRelationships.
+func (c *permissionClient) filterKeys(ctx context.Context, f *pb.RelationshipFilter) []string {
+	if f == nil {
+		return nil
+	}
+	kf := c.keyFunc(ctx)
+	keys := []string{kf(typeKey(f.ResourceType))}
+	if f.OptionalResourceId != "" {
+		keys = append(keys, kf(Key{ObjectType: f.ResourceType, ObjectID: f.OptionalResourceId}))
+	}
+	if sf := f.OptionalSubjectFilter; sf != nil {
+		if sf.SubjectType != f.ResourceType {
+			keys = append(keys, kf(typeKey(sf.SubjectType)))
+		}
+		if sf.OptionalSubjectId != "" {
+			// Without a relation filter, subject sets of any relation match, which are invalidated by the type keys.
+			k := Key{ObjectType: sf.SubjectType, ObjectID: sf.OptionalSubjectId}
+			if sf.OptionalRelation != nil {
+				k.Relation = sf.OptionalRelation.Relation
+			}
+			keys = append(keys, kf(k))
+		}
 	}
 
-	return fmt.Sprintf("%s#%s", r.ObjectType, r.ObjectId)
+	return keys
 }
 
-func sprintSubjectReference(r *pb.SubjectReference) string {
-	if r == nil || r.Object == nil {
-		panic("can not print nil object reference")
+// setAll writes the given token to all keys in a single batch, unless they already cache a newer token.
+func setAll(ctx context.Context, ca cache.ContextCache, ttl time.Duration, keys []string, token string) error {
+	values := make(map[string]string, len(keys))
+	for _, k := range keys {
+		values[k] = token
 	}
 
-	return sprintObjectReference(r.Object)
+	return cache.SetMulti(ctx, ca, values, ttl, zedtoken.Compare)
 }
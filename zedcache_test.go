@@ -2,8 +2,12 @@ package zedcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,12 +15,19 @@ import (
 	"github.com/authzed/authzed-go/v1"
 	"github.com/efficientgo/e2e"
 	cache "github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
+	"github.com/connylabs/zedcache/cache/broadcast"
 	"github.com/connylabs/zedcache/cache/go-cache"
+	"github.com/connylabs/zedcache/spicedbtest"
+	"github.com/connylabs/zedcache/zedtoken"
 )
 
 func TestCheckPermission(t *testing.T) {
@@ -104,6 +115,28 @@ func TestCheckPermission(t *testing.T) {
 
 	getCacheValue(t, ca, "user#1")
 	assert.Equal(t, lrrRecv.LookedUpAt.Token, getCacheValue(t, ca, "user#1"))
+
+	drr, err := c.DeleteRelationships(ctx, &pb.DeleteRelationshipsRequest{
+		RelationshipFilter: &pb.RelationshipFilter{
+			ResourceType: "post",
+			OptionalSubjectFilter: &pb.SubjectFilter{
+				SubjectType:       "user",
+				OptionalSubjectId: "1",
+			},
+		},
+	})
+	assert.NoError(t, err)
+	// The deleted posts are invalidated by the dependency key of their type.
+	assert.Equal(t, drr.DeletedAt.Token, getCacheValue(t, ca, "->post"))
+	assert.Equal(t, drr.DeletedAt.Token, getCacheValue(t, ca, "user#1"))
+}
+
+// seedDependencies caches the token for the dependency keys of the object types,
+// so that requests for them are not evaluated fully consistently because the dependency keys are missing.
+func seedDependencies(ca *cache.Cache, token string, types ...string) {
+	for _, t := range types {
+		ca.Set(DefaultKeyFunc(dependencyKey(t)), token, cache.NoExpiration)
+	}
 }
 
 func getCacheValue(t *testing.T, ca *cache.Cache, key string) string {
@@ -138,3 +171,671 @@ func waitForAuthZed(t *testing.T, ctx context.Context, ac *authzed.Client) {
 	}
 	require.Fail(t, "authzed not ready")
 }
+
+type staleCheckClient struct {
+	pb.PermissionsServiceClient
+
+	consistencies []*pb.Consistency
+}
+
+func (c *staleCheckClient) CheckPermission(_ context.Context, in *pb.CheckPermissionRequest, _ ...grpc.CallOption) (*pb.CheckPermissionResponse, error) {
+	c.consistencies = append(c.consistencies, in.Consistency)
+	if in.Consistency.GetAtLeastAsFresh() != nil {
+		return nil, status.Error(codes.OutOfRange, "invalid zedtoken: revision has expired")
+	}
+
+	return &pb.CheckPermissionResponse{
+		CheckedAt:      &pb.ZedToken{Token: zedtoken.New("2")},
+		Permissionship: pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION,
+	}, nil
+}
+
+func TestStaleToken(t *testing.T) {
+	ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+	ca.Set("post#1", zedtoken.New("1"), cache.NoExpiration)
+	ca.Set("->post", zedtoken.New("1"), cache.NoExpiration)
+	pc := &staleCheckClient{}
+	r := prometheus.NewRegistry()
+	c := NewPermissionServiceClient(pc, gocache.New(ca), WithRegisterer(r))
+
+	res, err := c.CheckPermission(context.Background(), &pb.CheckPermissionRequest{
+		Permission: "read",
+		Resource:   &pb.ObjectReference{ObjectType: "post", ObjectId: "1"},
+		Subject: &pb.SubjectReference{
+			Object: &pb.ObjectReference{
+				ObjectType: "user",
+				ObjectId:   "1",
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, res.Permissionship)
+
+	require.Len(t, pc.consistencies, 2)
+	assert.Equal(t, zedtoken.New("1"), pc.consistencies[0].GetAtLeastAsFresh().GetToken())
+	assert.True(t, pc.consistencies[1].GetFullyConsistent())
+	assert.Equal(t, zedtoken.New("2"), getCacheValue(t, ca, "post#1"))
+	assert.NoError(t, testutil.GatherAndCompare(r, strings.NewReader(`
+# HELP zedcache_stale_tokens_total Number of requests that failed because the cached zedtoken was expired or invalid and were retried with the fallback consistency.
+# TYPE zedcache_stale_tokens_total counter
+zedcache_stale_tokens_total{method="CheckPermission"} 1
+`), "zedcache_stale_tokens_total"))
+}
+
+func TestIsStaleTokenError(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		stale bool
+	}{
+		{err: nil},
+		{err: errors.New("invalid zedtoken")},
+		{err: status.Error(codes.OutOfRange, "invalid zedtoken: revision has expired"), stale: true},
+		{err: status.Error(codes.InvalidArgument, "failed to decode zedtoken"), stale: true},
+		{err: status.Error(codes.FailedPrecondition, "invalid revision requested"), stale: true},
+		{err: status.Error(codes.InvalidArgument, "object definition `foo` not found")},
+		{err: status.Error(codes.Unavailable, "connection refused")},
+	} {
+		assert.Equal(t, tc.stale, isStaleTokenError(tc.err), tc.err)
+	}
+}
+
+// deleteHookClient calls the before hook ahead of deleting relationships.
+type deleteHookClient struct {
+	pb.PermissionsServiceClient
+
+	before func()
+}
+
+func (c *deleteHookClient) DeleteRelationships(ctx context.Context, in *pb.DeleteRelationshipsRequest, opts ...grpc.CallOption) (*pb.DeleteRelationshipsResponse, error) {
+	c.before()
+
+	return c.PermissionsServiceClient.DeleteRelationships(ctx, in, opts...)
+}
+
+// lookupClient returns lookup responses without zedtokens.
+type lookupClient struct {
+	pb.PermissionsServiceClient
+}
+
+func (*lookupClient) LookupResources(context.Context, *pb.LookupResourcesRequest, ...grpc.CallOption) (pb.PermissionsService_LookupResourcesClient, error) {
+	return &lookupResourcesStream{res: []*pb.LookupResourcesResponse{{ResourceObjectId: "1"}}}, nil
+}
+
+func (*lookupClient) LookupSubjects(context.Context, *pb.LookupSubjectsRequest, ...grpc.CallOption) (pb.PermissionsService_LookupSubjectsClient, error) {
+	return &lookupSubjectsStream{res: []*pb.LookupSubjectsResponse{{SubjectObjectId: "1"}}}, nil
+}
+
+type lookupResourcesStream struct {
+	grpc.ClientStream
+
+	res []*pb.LookupResourcesResponse
+}
+
+func (s *lookupResourcesStream) Recv() (*pb.LookupResourcesResponse, error) {
+	if len(s.res) == 0 {
+		return nil, io.EOF
+	}
+	res := s.res[0]
+	s.res = s.res[1:]
+
+	return res, nil
+}
+
+type lookupSubjectsStream struct {
+	grpc.ClientStream
+
+	res []*pb.LookupSubjectsResponse
+}
+
+func (s *lookupSubjectsStream) Recv() (*pb.LookupSubjectsResponse, error) {
+	if len(s.res) == 0 {
+		return nil, io.EOF
+	}
+	res := s.res[0]
+	s.res = s.res[1:]
+
+	return res, nil
+}
+
+func TestLookupWithoutZedToken(t *testing.T) {
+	ctx := context.Background()
+	ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+	c := NewPermissionServiceClient(&lookupClient{}, gocache.New(ca))
+	post1 := &pb.ObjectReference{ObjectType: "post", ObjectId: "1"}
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+
+	lr, err := c.LookupResources(ctx, &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: user1})
+	require.NoError(t, err)
+	res, err := lr.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "1", res.ResourceObjectId)
+
+	ls, err := c.LookupSubjects(ctx, &pb.LookupSubjectsRequest{Resource: post1, Permission: "read", SubjectObjectType: "user"})
+	require.NoError(t, err)
+	sres, err := ls.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "1", sres.SubjectObjectId)
+
+	assert.Zero(t, ca.ItemCount())
+}
+
+type checkClient struct {
+	pb.PermissionsServiceClient
+}
+
+func (*checkClient) CheckPermission(context.Context, *pb.CheckPermissionRequest, ...grpc.CallOption) (*pb.CheckPermissionResponse, error) {
+	return &pb.CheckPermissionResponse{
+		CheckedAt:      &pb.ZedToken{Token: zedtoken.New("1")},
+		Permissionship: pb.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION,
+	}, nil
+}
+
+func TestMetrics(t *testing.T) {
+	r := prometheus.NewRegistry()
+	c := NewPermissionServiceClient(&checkClient{}, gocache.New(cache.New(cache.NoExpiration, cache.NoExpiration)), WithRegisterer(r))
+
+	for i := 0; i < 3; i++ {
+		_, err := c.CheckPermission(context.Background(), &pb.CheckPermissionRequest{
+			Permission: "read",
+			Resource:   &pb.ObjectReference{ObjectType: "post", ObjectId: "1"},
+			Subject: &pb.SubjectReference{
+				Object: &pb.ObjectReference{
+					ObjectType: "user",
+					ObjectId:   "1",
+				},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	assert.NoError(t, testutil.GatherAndCompare(r, strings.NewReader(`
+# HELP zedcache_lookups_total Number of zedtokens that were looked up in the cache by result.
+# TYPE zedcache_lookups_total counter
+zedcache_lookups_total{backend="gocache.Cache",method="CheckPermission",result="hit"} 2
+zedcache_lookups_total{backend="gocache.Cache",method="CheckPermission",result="miss"} 1
+# HELP zedcache_requests_total Number of requests sent to SpiceDB by consistency requirement and result.
+# TYPE zedcache_requests_total counter
+zedcache_requests_total{consistency="at_least_as_fresh",method="CheckPermission",result="success"} 2
+zedcache_requests_total{consistency="fully_consistent",method="CheckPermission",result="success"} 1
+`), "zedcache_lookups_total", "zedcache_requests_total"))
+	// One series for get_multi, one for set_multi, which also caches the missing dependency key, and one for set_if_newer.
+	assert.Equal(t, 3, testutil.CollectAndCount(r, "zedcache_cache_operations_total"))
+}
+
+func TestMetricsSharedRegisterer(t *testing.T) {
+	r := prometheus.NewRegistry()
+	ca := gocache.New(cache.New(cache.NoExpiration, cache.NoExpiration))
+	c1 := NewPermissionServiceClient(&checkClient{}, ca, WithRegisterer(r))
+	c2 := NewPermissionServiceClient(&checkClient{}, ca, WithRegisterer(r))
+	require.NotPanics(t, func() { NewInterceptor(ca, WithRegisterer(r)) })
+
+	for _, c := range []pb.PermissionsServiceClient{c1, c2} {
+		_, err := c.CheckPermission(context.Background(), &pb.CheckPermissionRequest{
+			Permission: "read",
+			Resource:   &pb.ObjectReference{ObjectType: "post", ObjectId: "1"},
+			Subject: &pb.SubjectReference{
+				Object: &pb.ObjectReference{
+					ObjectType: "user",
+					ObjectId:   "1",
+				},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	// Both clients record their requests in the same collectors.
+	assert.NoError(t, testutil.GatherAndCompare(r, strings.NewReader(`
+# HELP zedcache_requests_total Number of requests sent to SpiceDB by consistency requirement and result.
+# TYPE zedcache_requests_total counter
+zedcache_requests_total{consistency="at_least_as_fresh",method="CheckPermission",result="success"} 1
+zedcache_requests_total{consistency="fully_consistent",method="CheckPermission",result="success"} 1
+`), "zedcache_requests_total"))
+}
+
+func TestPermissionClient(t *testing.T) {
+	ctx := context.Background()
+	post1 := &pb.ObjectReference{ObjectType: "post", ObjectId: "1"}
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+	checkPost1 := func(t *testing.T, c pb.PermissionsServiceClient) {
+		t.Helper()
+
+		_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Permission: "read", Resource: post1, Subject: user1})
+		require.NoError(t, err)
+	}
+	writePost1 := func(t *testing.T, c pb.PermissionsServiceClient) string {
+		t.Helper()
+
+		res, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: user1},
+			}},
+		})
+		require.NoError(t, err)
+		return res.WrittenAt.Token
+	}
+	drain := func(t *testing.T, recv func() error) {
+		t.Helper()
+
+		for {
+			err := recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("miss and hit", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+
+		checkPost1(t, c)
+		checkPost1(t, c)
+
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 2)
+		assert.True(t, cs[0].GetFullyConsistent())
+		assert.Equal(t, s.Token(), cs[1].GetAtLeastAsFresh().GetToken())
+		assert.Equal(t, s.Token(), getCacheValue(t, ca, "post#1"))
+	})
+
+	t.Run("caller consistency", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		ca.Set("post#1", s.Token(), cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+
+		_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{
+			Consistency: &pb.Consistency{Requirement: &pb.Consistency_MinimizeLatency{MinimizeLatency: true}},
+			Permission:  "read",
+			Resource:    post1,
+			Subject:     user1,
+		})
+		require.NoError(t, err)
+
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 1)
+		assert.True(t, cs[0].GetMinimizeLatency())
+	})
+
+	t.Run("write", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+		seedDependencies(ca, s.Token(), "post", "user")
+
+		checkPost1(t, c)
+		written := writePost1(t, c)
+		assert.Equal(t, written, getCacheValue(t, ca, "post#1"))
+		assert.Equal(t, written, getCacheValue(t, ca, "user#1"))
+
+		checkPost1(t, c)
+		lr, err := c.LookupResources(ctx, &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: user1})
+		require.NoError(t, err)
+		drain(t, func() error {
+			_, err := lr.Recv()
+			return err
+		})
+
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 2)
+		assert.Equal(t, written, cs[1].GetAtLeastAsFresh().GetToken())
+		cs = s.Consistencies("LookupResources")
+		require.Len(t, cs, 1)
+		assert.Equal(t, written, cs[0].GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("object types", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+		seedDependencies(ca, s.Token(), "post", "user")
+		readPosts := func(t *testing.T) {
+			t.Helper()
+
+			rr, err := c.ReadRelationships(ctx, &pb.ReadRelationshipsRequest{RelationshipFilter: &pb.RelationshipFilter{ResourceType: "post"}})
+			require.NoError(t, err)
+			drain(t, func() error {
+				_, err := rr.Recv()
+				return err
+			})
+		}
+
+		written := writePost1(t, c)
+		assert.Equal(t, written, getCacheValue(t, ca, "type:post"))
+		assert.Equal(t, written, getCacheValue(t, ca, "type:user"))
+		readPosts(t)
+
+		// A write to another post advances the zedtoken of all posts.
+		res, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation: pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{
+					Resource: &pb.ObjectReference{ObjectType: "post", ObjectId: "2"},
+					Relation: "read",
+					Subject:  &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "2"}},
+				},
+			}},
+		})
+		require.NoError(t, err)
+		readPosts(t)
+
+		// LookupResources requires the zedtokens of the subject and of the resource type.
+		checkPost1(t, c)
+		lr, err := c.LookupResources(ctx, &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: user1})
+		require.NoError(t, err)
+		drain(t, func() error {
+			_, err := lr.Recv()
+			return err
+		})
+
+		cs := s.Consistencies("ReadRelationships")
+		require.Len(t, cs, 2)
+		assert.Equal(t, written, cs[0].GetAtLeastAsFresh().GetToken())
+		assert.Equal(t, res.WrittenAt.Token, cs[1].GetAtLeastAsFresh().GetToken())
+		cs = s.Consistencies("LookupResources")
+		require.Len(t, cs, 1)
+		assert.Equal(t, res.WrittenAt.Token, cs[0].GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("subject sets and wildcards", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+		seedDependencies(ca, s.Token(), "post", "group")
+		members := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "group", ObjectId: "eng"}, OptionalRelation: "member"}
+		everyone := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "*"}}
+
+		res, err := c.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{
+				{
+					Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+					Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: members},
+				},
+				{
+					Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+					Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: everyone},
+				},
+			},
+		})
+		require.NoError(t, err)
+		written := res.WrittenAt.Token
+		assert.Equal(t, written, getCacheValue(t, ca, "group#eng#member"))
+		assert.Equal(t, written, getCacheValue(t, ca, "user#*"))
+		// The group itself is not changed by a write that uses its members as subject.
+		_, ok := ca.Get("group#eng")
+		assert.False(t, ok)
+
+		lr, err := c.LookupResources(ctx, &pb.LookupResourcesRequest{ResourceObjectType: "post", Permission: "read", Subject: members})
+		require.NoError(t, err)
+		drain(t, func() error {
+			_, err := lr.Recv()
+			return err
+		})
+		cs := s.Consistencies("LookupResources")
+		require.Len(t, cs, 1)
+		assert.Equal(t, written, cs[0].GetAtLeastAsFresh().GetToken())
+
+		// The public access advances the zedtoken of all posts, so lookups for every user see it.
+		assert.Equal(t, written, getCacheValue(t, ca, "type:post"))
+	})
+
+	t.Run("key func", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca), WithKeyFunc(func(k Key) string {
+			return "zedcache/" + DefaultKeyFunc(k)
+		}))
+		ca.Set("zedcache/->post", s.Token(), cache.NoExpiration)
+
+		written := writePost1(t, c)
+		assert.Equal(t, written, getCacheValue(t, ca, "zedcache/post#1"))
+		assert.Equal(t, written, getCacheValue(t, ca, "zedcache/type:post"))
+		_, ok := ca.Get("post#1")
+		assert.False(t, ok)
+
+		checkPost1(t, c)
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 1)
+		assert.Equal(t, written, cs[0].GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		type tenantKey struct{}
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca), WithNamespaceFunc(func(ctx context.Context) string {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			return tenant
+		}))
+		ctxA := context.WithValue(ctx, tenantKey{}, "a")
+		ctxB := context.WithValue(ctx, tenantKey{}, "b")
+		ca.Set("a:->post", s.Token(), cache.NoExpiration)
+
+		res, err := c.WriteRelationships(ctxA, &pb.WriteRelationshipsRequest{
+			Updates: []*pb.RelationshipUpdate{{
+				Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+				Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: user1},
+			}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, res.WrittenAt.Token, getCacheValue(t, ca, "a:post#1"))
+		assert.Equal(t, res.WrittenAt.Token, getCacheValue(t, ca, "a:type:post"))
+		_, ok := ca.Get("post#1")
+		assert.False(t, ok)
+
+		for _, ctx := range []context.Context{ctxA, ctxB} {
+			_, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Permission: "read", Resource: post1, Subject: user1})
+			require.NoError(t, err)
+		}
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 2)
+		assert.Equal(t, res.WrittenAt.Token, cs[0].GetAtLeastAsFresh().GetToken())
+		// Tenant b does not use the zedtoken of tenant a.
+		assert.True(t, cs[1].GetFullyConsistent())
+
+		// Requests without a tenant are prefixed, too.
+		writePost1(t, c)
+		getCacheValue(t, ca, ":post#1")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+
+		writePost1(t, c)
+		res, err := c.DeleteRelationships(ctx, &pb.DeleteRelationshipsRequest{
+			RelationshipFilter: &pb.RelationshipFilter{
+				ResourceType:          "post",
+				OptionalSubjectFilter: &pb.SubjectFilter{SubjectType: "user", OptionalSubjectId: "1"},
+			},
+		})
+		require.NoError(t, err)
+		for _, k := range []string{"type:post", "type:user", "user#1", "->post"} {
+			assert.Equal(t, res.DeletedAt.Token, getCacheValue(t, ca, k))
+		}
+		// The keys are derived from the filter without reading the relationships.
+		assert.Empty(t, s.Consistencies("ReadRelationships"))
+
+		// Resources that the filter does not name are evaluated at least as fresh as the deletion.
+		checkPost1(t, c)
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 1)
+		assert.Equal(t, res.DeletedAt.Token, cs[0].GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("write during delete", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		dc := &deleteHookClient{PermissionsServiceClient: s}
+		c := NewPermissionServiceClient(dc, gocache.New(ca))
+
+		// The relationship is written after the cache was invalidated and before it is deleted.
+		var written string
+		dc.before = func() {
+			written = writePost1(t, c)
+		}
+		res, err := c.DeleteRelationships(ctx, &pb.DeleteRelationshipsRequest{
+			RelationshipFilter: &pb.RelationshipFilter{ResourceType: "post"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, written, getCacheValue(t, ca, "post#1"))
+
+		checkPost1(t, c)
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 1)
+		assert.Equal(t, res.DeletedAt.Token, cs[0].GetAtLeastAsFresh().GetToken())
+	})
+
+	t.Run("stale token", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+		seedDependencies(ca, s.Token(), "post", "user")
+
+		checkPost1(t, c)
+		stale := getCacheValue(t, ca, "post#1")
+		// LookupSubjects also requires a zedtoken for the subject type.
+		ca.Set("type:user", stale, cache.NoExpiration)
+		// A write that bypasses the cache moves SpiceDB to a new revision.
+		writePost1(t, s)
+		s.GarbageCollect()
+
+		ls, err := c.LookupSubjects(ctx, &pb.LookupSubjectsRequest{Resource: post1, Permission: "read", SubjectObjectType: "user"})
+		require.NoError(t, err)
+		drain(t, func() error {
+			_, err := ls.Recv()
+			return err
+		})
+
+		cs := s.Consistencies("LookupSubjects")
+		require.Len(t, cs, 2)
+		assert.Equal(t, stale, cs[0].GetAtLeastAsFresh().GetToken())
+		assert.True(t, cs[1].GetFullyConsistent())
+		assert.Equal(t, s.Token(), getCacheValue(t, ca, "post#1"))
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		s := spicedbtest.New()
+		ca := cache.New(cache.NoExpiration, cache.NoExpiration)
+		c := NewPermissionServiceClient(s, gocache.New(ca))
+		w := NewWatcher(s, gocache.New(ca), WithStartCursor(&pb.ZedToken{Token: s.Token()}))
+		seedDependencies(ca, s.Token(), "post")
+
+		wctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- w.Run(wctx)
+		}()
+		written := writePost1(t, s)
+		require.Eventually(t, func() bool {
+			return w.Cursor() != nil && w.Cursor().Token == written
+		}, time.Second, time.Millisecond)
+		cancel()
+		require.NoError(t, <-done)
+
+		checkPost1(t, c)
+		cs := s.Consistencies("CheckPermission")
+		require.Len(t, cs, 1)
+		assert.Equal(t, written, cs[0].GetAtLeastAsFresh().GetToken())
+	})
+}
+
+// syncBus is a broadcast.Bus that delivers every message to all subscribers before Publish returns.
+type syncBus struct {
+	mu   sync.Mutex
+	subs []func(keys ...string)
+}
+
+func (b *syncBus) Publish(_ context.Context, keys ...string) error {
+	b.mu.Lock()
+	subs := append([]func(keys ...string){}, b.subs...)
+	b.mu.Unlock()
+
+	for _, f := range subs {
+		f(keys...)
+	}
+
+	return nil
+}
+
+func (b *syncBus) Subscribe(ctx context.Context, ready func(), f func(keys ...string)) error {
+	ready()
+	b.mu.Lock()
+	b.subs = append(b.subs, f)
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func (b *syncBus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs)
+}
+
+// newBroadcastCaches returns n caches that share the Bus and are subscribed to it.
+func newBroadcastCaches(t *testing.T, n int) []*broadcast.Cache {
+	t.Helper()
+
+	b := &syncBus{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	caches := make([]*broadcast.Cache, n)
+	for i := range caches {
+		caches[i] = broadcast.New(gocache.New(cache.New(cache.NoExpiration, cache.NoExpiration)), b)
+		go caches[i].Run(ctx)
+	}
+	require.Eventually(t, func() bool {
+		return b.subscribers() == n
+	}, time.Second, time.Millisecond)
+
+	return caches
+}
+
+func TestBroadcast(t *testing.T) {
+	ctx := context.Background()
+	post1 := &pb.ObjectReference{ObjectType: "post", ObjectId: "1"}
+	user1 := &pb.SubjectReference{Object: &pb.ObjectReference{ObjectType: "user", ObjectId: "1"}}
+	s := spicedbtest.New()
+	caches := newBroadcastCaches(t, 2)
+	a := NewPermissionServiceClient(s, caches[0])
+	b := NewPermissionServiceClient(s, caches[1])
+	check := func(t *testing.T, c pb.PermissionsServiceClient) *pb.CheckPermissionResponse {
+		t.Helper()
+
+		res, err := c.CheckPermission(ctx, &pb.CheckPermissionRequest{Permission: "read", Resource: post1, Subject: user1})
+		require.NoError(t, err)
+
+		return res
+	}
+
+	_, err := a.WriteRelationships(ctx, &pb.WriteRelationshipsRequest{
+		Updates: []*pb.RelationshipUpdate{{
+			Operation:    pb.RelationshipUpdate_OPERATION_TOUCH,
+			Relationship: &pb.Relationship{Resource: post1, Relation: "read", Subject: user1},
+		}},
+	})
+	require.NoError(t, err)
+	// The second check of instance b is served from its local cache.
+	check(t, b)
+	check(t, b)
+	cs := s.Consistencies("CheckPermission")
+	require.Len(t, cs, 2)
+	assert.NotNil(t, cs[1].GetAtLeastAsFresh())
+
+	// The deletion removes the dependency key of posts from the local cache of instance b.
+	_, err = a.DeleteRelationships(ctx, &pb.DeleteRelationshipsRequest{RelationshipFilter: &pb.RelationshipFilter{ResourceType: "post"}})
+	require.NoError(t, err)
+	res := check(t, b)
+	assert.Equal(t, pb.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION, res.Permissionship)
+	cs = s.Consistencies("CheckPermission")
+	require.Len(t, cs, 3)
+	assert.True(t, cs[2].GetFullyConsistent())
+}
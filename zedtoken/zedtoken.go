@@ -0,0 +1,137 @@
+// Package zedtoken decodes the revisions that are encoded in SpiceDB's zedtokens.
+// This allows to determine which of two zedtokens is newer.
+package zedtoken
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ErrMalformed is returned if a zedtoken could not be decoded.
+var ErrMalformed = errors.New("malformed zedtoken")
+
+// Field numbers of SpiceDB's DecodedZedToken message.
+const (
+	deprecatedV1ZookieField protowire.Number = 2
+	v1ZedTokenField         protowire.Number = 3
+	revisionField           protowire.Number = 1
+)
+
+// New encodes the given revision into a zedtoken in the same format as SpiceDB.
+func New(revision string) string {
+	var inner []byte
+	inner = protowire.AppendTag(inner, revisionField, protowire.BytesType)
+	inner = protowire.AppendString(inner, revision)
+
+	var b []byte
+	b = protowire.AppendTag(b, v1ZedTokenField, protowire.BytesType)
+	b = protowire.AppendBytes(b, inner)
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Decode returns the revision encoded in the given zedtoken.
+// Revisions are decimal numbers, e.g. "42" or "1669127218345366000.0000000001"
+// for datastores that use hybrid logical clocks.
+func Decode(token string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrMalformed, err.Error())
+	}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", fmt.Errorf("%w: %s", ErrMalformed, protowire.ParseError(n).Error())
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType || (num != deprecatedV1ZookieField && num != v1ZedTokenField) {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return "", fmt.Errorf("%w: %s", ErrMalformed, protowire.ParseError(n).Error())
+			}
+			b = b[n:]
+			continue
+		}
+
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", fmt.Errorf("%w: %s", ErrMalformed, protowire.ParseError(n).Error())
+		}
+
+		return decodeRevision(num, msg)
+	}
+
+	return "", fmt.Errorf("%w: no revision found", ErrMalformed)
+}
+
+// decodeRevision decodes the revision field of the given version message.
+func decodeRevision(version protowire.Number, b []byte) (string, error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", fmt.Errorf("%w: %s", ErrMalformed, protowire.ParseError(n).Error())
+		}
+		b = b[n:]
+
+		switch {
+		case num == revisionField && version == v1ZedTokenField && typ == protowire.BytesType:
+			rev, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return "", fmt.Errorf("%w: %s", ErrMalformed, protowire.ParseError(n).Error())
+			}
+
+			return rev, nil
+		case num == revisionField && version == deprecatedV1ZookieField && typ == protowire.VarintType:
+			rev, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return "", fmt.Errorf("%w: %s", ErrMalformed, protowire.ParseError(n).Error())
+			}
+
+			return strconv.FormatUint(rev, 10), nil
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return "", fmt.Errorf("%w: %s", ErrMalformed, protowire.ParseError(n).Error())
+		}
+		b = b[n:]
+	}
+
+	// proto3 omits zero values.
+	return "0", nil
+}
+
+// Compare compares the revisions of the given zedtokens.
+// The result will be 0 if a and b represent the same revision, -1 if a is older than b, and +1 if a is newer than b.
+func Compare(a, b string) (int, error) {
+	ra, err := revision(a)
+	if err != nil {
+		return 0, err
+	}
+	rb, err := revision(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return ra.Cmp(rb), nil
+}
+
+func revision(token string) (*big.Rat, error) {
+	rev, err := Decode(token)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := new(big.Rat).SetString(rev)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid revision %q", ErrMalformed, rev)
+	}
+
+	return r, nil
+}
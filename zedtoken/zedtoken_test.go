@@ -0,0 +1,74 @@
+package zedtoken
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		token    string
+		revision string
+		err      error
+	}{
+		{
+			name:     "v1",
+			token:    "GhUKEzE2NjkxMjcyMTgzNDUzNjYwMDA=",
+			revision: "1669127218345366000",
+		},
+		{
+			name:     "deprecated v1 zookie",
+			token:    "EgIIKg==",
+			revision: "42",
+		},
+		{
+			name:     "round trip",
+			token:    New("1669127218345366000.0000000001"),
+			revision: "1669127218345366000.0000000001",
+		},
+		{
+			name:  "invalid base64",
+			token: "not a token",
+			err:   ErrMalformed,
+		},
+		{
+			name:  "empty",
+			token: "",
+			err:   ErrMalformed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rev, err := Decode(tc.token)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.revision, rev)
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		cmp  int
+	}{
+		{a: "1", b: "2", cmp: -1},
+		{a: "2", b: "1", cmp: 1},
+		{a: "10", b: "9", cmp: 1},
+		{a: "3", b: "3", cmp: 0},
+		{a: "1669127218345366000.0000000001", b: "1669127218345366000", cmp: 1},
+		{a: "1669127218345366000.0000000001", b: "1669127218345366000.0000000002", cmp: -1},
+	} {
+		cmp, err := Compare(New(tc.a), New(tc.b))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.cmp, cmp, "%s <=> %s", tc.a, tc.b)
+	}
+
+	_, err := Compare(New("1"), "invalid")
+	assert.ErrorIs(t, err, ErrMalformed)
+}